@@ -1,24 +1,92 @@
 package main
 
 import (
-	"time"
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/suman7383/go-queue/internal/config"
 	s "github.com/suman7383/go-queue/internal/http"
 	"github.com/suman7383/go-queue/internal/queue"
 )
 
 func main() {
-	config := queue.TopicConfig{
-		AckTimeout: 30 * time.Second,
-		MaxRetries: 3,
+	cfg, err := config.Load(os.Args[1:], os.Environ())
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
+	if err != nil {
+		log.Fatalf("[Config ERROR] %v\n", err)
+	}
+
+	queue.DataDir = cfg.DataDir
 
 	// create a registry
-	registry := queue.NewTopicRegistry(config)
+	registry := queue.NewTopicRegistry(cfg.Topic)
+
+	server := s.NewHttpServer(registry)
+	server.MinFreeDisk = cfg.MinFreeDisk / 100
+
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Serve right away so /healthz and /readyz answer during recovery,
+	// topic routes answer 503 until it's done
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Start(cfg.Listen) }()
 
 	// Recover topics from disk BEFORE producers/consumers
-	registry.LoadTopicFromDisk(config)
+	exitCode := 0
+	if err := registry.LoadTopicFromDisk(cfg.Topic); err != nil {
+		log.Printf("[Recovery ERROR] %v\n", err)
+		shutdown(server, registry, cfg)
+		os.Exit(1)
+	}
 
-	server := s.NewHttpServer(registry)
-	server.Start(":8080")
+	select {
+	case err := <-serveErr:
+		log.Printf("[HTTP ERROR] %v\n", err)
+		exitCode = 1
+	case <-signals.Done():
+		log.Println("[Shutdown] Signal received, draining requests")
+	}
+	stop() // a second signal kills the process
+
+	if !shutdown(server, registry, cfg) {
+		exitCode = 1
+	}
+	os.Exit(exitCode)
+}
+
+// shutdown stops serving, gives requests in progress cfg.ShutdownTimeout
+// to finish, then cfg.CloseTimeout to close every topic. Topics not
+// flushed by then are logged, exiting loses their latest writes.
+// Reports whether both stages finished in time.
+func shutdown(server *s.HTTPServer, registry *queue.TopicRegistry, cfg config.Config) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	drained := true
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("[Shutdown ERROR] HTTP: requests not finished within %v: %v\n", cfg.ShutdownTimeout, err)
+		drained = false
+	}
+
+	// Requests still running get ErrTopicClosed from here on
+	ctx, cancel = context.WithTimeout(context.Background(), cfg.CloseTimeout)
+	defer cancel()
+
+	if pending := registry.CloseWithin(ctx); len(pending) > 0 {
+		log.Printf("[Shutdown ERROR] Topics not flushed within %v: %s\n", cfg.CloseTimeout, strings.Join(pending, ", "))
+		return false
+	}
+	log.Println("[Shutdown] Every topic flushed, bye")
+
+	return drained
 }
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/suman7383/go-queue/internal/queue"
+)
+
+// Config is the server's configuration. Each source overrides the
+// previous ones: built-in defaults, the TOML config file, GOQUEUE_*
+// environment variables and command-line flags.
+//
+// Topic settings use the keys of the topic API, eg: ack_timeout is set
+// with `ack_timeout = "30s"` under [topic] in the file,
+// GOQUEUE_ACK_TIMEOUT=30s or -ack-timeout=30s
+type Config struct {
+	Listen  string            // HTTP listen address
+	DataDir string            // holds a directory per topic
+	Topic   queue.TopicConfig // for topics without a config of their own
+
+	// How long a shutdown waits for requests in progress to finish,
+	// then for topics to be flushed. Topics still flushing after
+	// CloseTimeout are logged and left behind
+	ShutdownTimeout time.Duration
+	CloseTimeout    time.Duration
+
+	// Percentage of the data directory's filesystem that must be free
+	// for the server to report ready
+	MinFreeDisk float64
+}
+
+// Prefix of the environment variables read by Load
+const envPrefix = "GOQUEUE_"
+
+// Default returns the config used when nothing is set
+func Default() Config {
+	return Config{
+		Listen:  ":8080",
+		DataDir: "data",
+		Topic: queue.TopicConfig{
+			AckTimeout: 30 * time.Second,
+			MaxRetries: 3,
+		},
+		ShutdownTimeout: 30 * time.Second,
+		CloseTimeout:    30 * time.Second,
+		MinFreeDisk:     5,
+	}
+}
+
+// Keys of the server settings, topic settings aside
+var serverKeys = []string{"config", "listen", "data_dir", "shutdown_timeout", "close_timeout", "min_free_disk"}
+
+// setting is a key = value read from the environment or a flag
+type setting struct {
+	source string // where it was read, for errors
+	key    string
+	value  string
+}
+
+// Load builds the config from args (without the program name) and
+// environ (as returned by os.Environ), reading the config file named by
+// -config or GOQUEUE_CONFIG if any
+func Load(args, environ []string) (Config, error) {
+	config := Default()
+
+	var flagged []setting
+	flags := flag.NewFlagSet("go-queue", flag.ContinueOnError)
+	path := flags.String("config", "", "TOML config file")
+	define := func(key, usage string) {
+		name := strings.ReplaceAll(key, "_", "-")
+		flags.Func(name, usage, func(value string) error {
+			flagged = append(flagged, setting{source: "-" + name, key: key, value: value})
+			return nil
+		})
+	}
+	define("listen", fmt.Sprintf("HTTP listen address (default %q)", config.Listen))
+	define("data_dir", fmt.Sprintf("directory holding the topics (default %q)", config.DataDir))
+	define("shutdown_timeout", fmt.Sprintf("longest a graceful shutdown waits for requests in progress (default %v)", config.ShutdownTimeout))
+	define("close_timeout", fmt.Sprintf("longest a graceful shutdown waits for topics to be flushed (default %v)", config.CloseTimeout))
+	define("min_free_disk", fmt.Sprintf("percentage of disk that must be free to be ready (default %v)", config.MinFreeDisk))
+	for _, key := range topicKeys() {
+		define(key, "default topic "+key+", see the topic API")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+	if flags.NArg() > 0 {
+		return config, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	env, err := readEnv(environ)
+	if err != nil {
+		return config, err
+	}
+	if *path == "" {
+		for _, s := range env {
+			if s.key == "config" {
+				*path = s.value
+			}
+		}
+	}
+
+	if *path != "" {
+		if err := config.loadFile(*path); err != nil {
+			return config, fmt.Errorf("config file %s: %w", *path, err)
+		}
+	}
+	for _, s := range append(env, flagged...) {
+		if err := config.set(s.key, s.value); err != nil {
+			return config, fmt.Errorf("%s: %w", s.source, err)
+		}
+	}
+
+	return config, config.Validate()
+}
+
+// Keys of the topic settings, sorted
+func topicKeys() []string {
+	data, _ := json.Marshal(queue.TopicConfig{})
+
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	return slices.Sorted(maps.Keys(fields))
+}
+
+// readEnv returns the GOQUEUE_* variables of environ as settings, by
+// name. Unknown names are rejected, they are most likely typos.
+func readEnv(environ []string) ([]setting, error) {
+	known := append(slices.Clone(serverKeys), topicKeys()...)
+
+	var settings []setting
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, envPrefix) {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimPrefix(name, envPrefix))
+		if !slices.Contains(known, key) {
+			return nil, fmt.Errorf("unknown environment variable %s", name)
+		}
+		settings = append(settings, setting{source: name, key: key, value: value})
+	}
+
+	slices.SortFunc(settings, func(a, b setting) int { return strings.Compare(a.source, b.source) })
+	return settings, nil
+}
+
+// loadFile applies the settings of the TOML file at path
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	doc, err := parseTOML(string(data))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(doc)) {
+		switch value := doc[key]; key {
+		case "listen", "data_dir", "shutdown_timeout", "close_timeout", "min_free_disk":
+			var s string
+			switch value := value.(type) {
+			case string:
+				s = value
+			case int64, float64:
+				s = fmt.Sprint(value)
+			default:
+				return fmt.Errorf("%s must be a string or a number", key)
+			}
+			if err := c.set(key, s); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		case "topic":
+			// The topic table is read like a topic API request
+			if _, ok := value.(map[string]any); !ok {
+				return errors.New("topic must be a table")
+			}
+			data, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &c.Topic); err != nil {
+				return fmt.Errorf("[topic]: %w", err)
+			}
+		default:
+			return fmt.Errorf("unknown key %q", key)
+		}
+	}
+
+	return nil
+}
+
+// set applies a single setting. Topic settings are read as JSON values,
+// falling back to a string, so both 3 and 30s work.
+func (c *Config) set(key, value string) error {
+	switch key {
+	case "config":
+		return nil // already read
+	case "listen":
+		c.Listen = value
+	case "data_dir":
+		c.DataDir = value
+	case "shutdown_timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		c.ShutdownTimeout = timeout
+	case "close_timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		c.CloseTimeout = timeout
+	case "min_free_disk":
+		percent, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		c.MinFreeDisk = percent
+	default:
+		raw := json.RawMessage(value)
+		if !json.Valid(raw) {
+			raw, _ = json.Marshal(value)
+		}
+
+		data, err := json.Marshal(map[string]json.RawMessage{key: raw})
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, &c.Topic)
+	}
+	return nil
+}
+
+// Validate reports every problem with c
+func (c Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("invalid listen address %q: %w", c.Listen, err))
+	}
+	if c.DataDir == "" {
+		errs = append(errs, errors.New("data_dir can't be empty"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout must be positive, got %v", c.ShutdownTimeout))
+	}
+	if c.CloseTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("close_timeout must be positive, got %v", c.CloseTimeout))
+	}
+	if c.MinFreeDisk < 0 || c.MinFreeDisk >= 100 {
+		errs = append(errs, fmt.Errorf("min_free_disk must be a percentage from 0 to below 100, got %v", c.MinFreeDisk))
+	}
+	if err := c.Topic.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suman7383/go-queue/internal/queue"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	file := `
+listen = ":9000"
+data_dir = "/var/lib/goqueue"
+min_free_disk = 10
+
+[topic]
+ack_timeout = "10s"
+max_retries = 5
+wal_batch_size = 200
+
+[topic.retry]
+kind = "exponential"
+delay = "1s"
+`
+	if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Load(
+		[]string{"-max-retries=7", "-listen", ":9100"},
+		[]string{"GOQUEUE_CONFIG=" + path, "GOQUEUE_MAX_RETRIES=6", "GOQUEUE_ACK_TIMEOUT=20s", "HOME=/root"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Config{
+		Listen:          ":9100",            // flag over file
+		DataDir:         "/var/lib/goqueue", // file over default
+		ShutdownTimeout: 30 * time.Second,   // default
+		CloseTimeout:    30 * time.Second,   // default
+		MinFreeDisk:     10,                 // number in the file
+		Topic: queue.TopicConfig{
+			AckTimeout:   20 * time.Second, // env over file
+			MaxRetries:   7,                // flag over env
+			Retry:        queue.RetryPolicy{Kind: queue.RetryExponential, Delay: time.Second},
+			WALBatchSize: 200,
+		},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Fatalf("got %+v, want %+v", config, want)
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		args    []string
+		environ []string
+	}{
+		{args: []string{"-ack-timeout=-1s"}},
+		{args: []string{"-listen=8080"}},
+		{args: []string{"-shutdown-timeout=0s"}},
+		{environ: []string{"GOQUEUE_CLOSE_TIMEOUT=-1s"}},
+		{args: []string{"-min-free-disk=100"}},
+		{args: []string{"-max-retries=many"}},
+		{environ: []string{"GOQUEUE_ACK_TIMOUT=1s"}},
+		{environ: []string{"GOQUEUE_CONFIG=does-not-exist.toml"}},
+	}
+	for _, tt := range tests {
+		if _, err := Load(tt.args, tt.environ); err == nil {
+			t.Errorf("args %v, env %v: no error", tt.args, tt.environ)
+		}
+	}
+
+	_, err := Load([]string{"-ack-timeout=0s"}, nil)
+	if !errors.Is(err, queue.ErrInvalidConfig) {
+		t.Fatalf("got %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestLoadRejectsUnitlessDurations(t *testing.T) {
+	file := func(topic string) string {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(path, []byte("[topic]\n"+topic), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return "GOQUEUE_CONFIG=" + path
+	}
+
+	tests := []struct {
+		args    []string
+		environ []string
+		want    string
+	}{
+		{args: []string{"-ack-timeout=30"}, want: `duration 30 needs a unit, eg: "30s"`},
+		{environ: []string{"GOQUEUE_ACK_TIMEOUT=30"}, want: `duration 30 needs a unit, eg: "30s"`},
+		{args: []string{`-retry={"delay": 5}`}, want: `duration 5 needs a unit, eg: "5s"`},
+		{environ: []string{file("message_ttl = 60")}, want: `duration 60 needs a unit, eg: "60s"`},
+		{environ: []string{file("retry = { schedule = [\"1s\", 2.5] }")}, want: `duration 2.5 needs a unit, eg: "2.5s"`},
+	}
+	for _, tt := range tests {
+		_, err := Load(tt.args, tt.environ)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("args %v, env %v: got %v, want %q", tt.args, tt.environ, err, tt.want)
+		}
+	}
+
+	// Zero needs no unit, and other numbers are still numbers
+	config, err := Load([]string{"-message-ttl=0", "-max-retries=5"}, []string{file("max_lease = 0")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Topic.MaxRetries != 5 {
+		t.Fatalf("got max_retries %d, want 5", config.Topic.MaxRetries)
+	}
+}
+
+func TestExampleConfigLoads(t *testing.T) {
+	config, err := Load([]string{"-config", "../../config.example.toml"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Topic.Retry.Kind != queue.RetryExponential || config.Topic.WALFlushInterval != 50*time.Millisecond {
+		t.Fatalf("got topic config %+v", config.Topic)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	doc, err := parseTOML(`
+# comment
+name = "a # b" # trailing
+[server.http]
+port = 8_080
+ratio = 0.5
+tags = ["x", 'y',]
+inline = { on = true, deep.key = -1 }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"name": "a # b",
+		"server": map[string]any{"http": map[string]any{
+			"port":   int64(8080),
+			"ratio":  0.5,
+			"tags":   []any{"x", "y"},
+			"inline": map[string]any{"on": true, "deep": map[string]any{"key": int64(-1)}},
+		}},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Fatalf("got %v, want %v", doc, want)
+	}
+
+	for _, bad := range []string{"a = 1\na = 2", "[t]\n[t]", "a = bare", "a = \"open", "a = [1, 2", "= 1", "a = 1 2"} {
+		if _, err := parseTOML(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
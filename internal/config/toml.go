@@ -0,0 +1,289 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML the config file needs: comments,
+// [tables] and [dotted.tables], key = value pairs (dotted keys too) with
+// strings, integers, floats, booleans, single-line arrays and inline
+// tables. Returns the document as nested maps.
+func parseTOML(data string) (map[string]any, error) {
+	doc := make(map[string]any)
+	table := doc
+
+	for n, line := range strings.Split(data, "\n") {
+		p := &tomlParser{s: line}
+		err := func() error {
+			p.skipSpace()
+			if p.done() {
+				return nil
+			}
+
+			if p.peek() == '[' {
+				p.pos++
+				path, err := p.keyPath()
+				if err != nil {
+					return err
+				}
+				if !p.consume(']') {
+					return p.errorf("expected ]")
+				}
+				if table, err = subTable(doc, path, true); err != nil {
+					return err
+				}
+				return p.end()
+			}
+
+			path, err := p.keyPath()
+			if err != nil {
+				return err
+			}
+			if !p.consume('=') {
+				return p.errorf("expected =")
+			}
+			value, err := p.value()
+			if err != nil {
+				return err
+			}
+			if err := setKey(table, path, value); err != nil {
+				return err
+			}
+			return p.end()
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// subTable returns the table at path below t, creating missing ones.
+// With header set the last table must not have been defined by a header
+// yet.
+func subTable(t map[string]any, path []string, header bool) (map[string]any, error) {
+	for i, key := range path {
+		switch v := t[key].(type) {
+		case nil:
+			sub := make(map[string]any)
+			t[key] = sub
+			t = sub
+		case map[string]any:
+			if header && i == len(path)-1 {
+				return nil, fmt.Errorf("table %s defined twice", strings.Join(path, "."))
+			}
+			t = v
+		default:
+			return nil, fmt.Errorf("key %s is not a table", strings.Join(path[:i+1], "."))
+		}
+	}
+	return t, nil
+}
+
+// setKey sets the dotted key path of t, which must not be set yet
+func setKey(t map[string]any, path []string, value any) error {
+	t, err := subTable(t, path[:len(path)-1], false)
+	if err != nil {
+		return err
+	}
+
+	key := path[len(path)-1]
+	if _, ok := t[key]; ok {
+		return fmt.Errorf("key %s defined twice", strings.Join(path, "."))
+	}
+	t[key] = value
+	return nil
+}
+
+type tomlParser struct {
+	s   string
+	pos int
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("column %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) done() bool {
+	return p.pos >= len(p.s) || p.s[p.pos] == '#'
+}
+
+func (p *tomlParser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t' || p.s[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// consume skips spaces and c, reporting whether c was there
+func (p *tomlParser) consume(c byte) bool {
+	p.skipSpace()
+	if p.peek() != c {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// end checks only spaces or a comment are left on the line
+func (p *tomlParser) end() error {
+	p.skipSpace()
+	if !p.done() {
+		return p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	return nil
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// keyPath reads a bare, possibly dotted, key
+func (p *tomlParser) keyPath() ([]string, error) {
+	var path []string
+	for {
+		p.skipSpace()
+		start := p.pos
+		for p.pos < len(p.s) && isBareKeyChar(p.s[p.pos]) {
+			p.pos++
+		}
+		if p.pos == start {
+			return nil, p.errorf("expected a key")
+		}
+		path = append(path, p.s[start:p.pos])
+
+		if !p.consume('.') {
+			return path, nil
+		}
+	}
+}
+
+func (p *tomlParser) value() (any, error) {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case c == '"':
+		return p.basicString()
+	case c == '\'':
+		end := strings.IndexByte(p.s[p.pos+1:], '\'')
+		if end < 0 {
+			return nil, p.errorf("unterminated string")
+		}
+		s := p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return s, nil
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	default:
+		return p.scalar()
+	}
+}
+
+func (p *tomlParser) basicString() (string, error) {
+	for end := p.pos + 1; end < len(p.s); end++ {
+		switch p.s[end] {
+		case '\\':
+			end++ // skip the escaped character
+		case '"':
+			s, err := strconv.Unquote(p.s[p.pos : end+1])
+			if err != nil {
+				return "", p.errorf("invalid string: %v", err)
+			}
+			p.pos = end + 1
+			return s, nil
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *tomlParser) array() ([]any, error) {
+	p.pos++ // [
+	values := []any{}
+	for {
+		if p.consume(']') {
+			return values, nil
+		}
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		if !p.consume(',') {
+			if !p.consume(']') {
+				return nil, p.errorf("expected , or ]")
+			}
+			return values, nil
+		}
+	}
+}
+
+func (p *tomlParser) inlineTable() (map[string]any, error) {
+	p.pos++ // {
+	table := make(map[string]any)
+	if p.consume('}') {
+		return table, nil
+	}
+
+	for {
+		path, err := p.keyPath()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume('=') {
+			return nil, p.errorf("expected =")
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := setKey(table, path, value); err != nil {
+			return nil, err
+		}
+
+		if !p.consume(',') {
+			if !p.consume('}') {
+				return nil, p.errorf("expected , or }")
+			}
+			return table, nil
+		}
+	}
+}
+
+// scalar reads a boolean, integer or float
+func (p *tomlParser) scalar() (any, error) {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t\r,]}#", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, p.errorf("expected a value")
+	}
+
+	number := strings.ReplaceAll(token, "_", "")
+	if i, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("column %d: invalid value %q (strings need quotes)", start+1, token)
+}
@@ -0,0 +1,28 @@
+package health
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrUnsupported is returned by DiskUsage where free space can't be read
+var ErrUnsupported = errors.New("not supported on this platform")
+
+// DirWritable creates dir if needed and checks a file can be written in it
+func DirWritable(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write([]byte("ok")); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
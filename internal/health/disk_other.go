@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package health
+
+// DiskUsage is not available on this platform
+func DiskUsage(dir string) (free, total uint64, err error) {
+	return 0, 0, ErrUnsupported
+}
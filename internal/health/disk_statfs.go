@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package health
+
+import "syscall"
+
+// DiskUsage returns the bytes available to unprivileged users and the
+// size of the filesystem holding dir
+func DiskUsage(dir string) (free, total uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	blockSize := uint64(stat.Bsize)
+	return uint64(stat.Bavail) * blockSize, uint64(stat.Blocks) * blockSize, nil
+}
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	q "github.com/suman7383/go-queue/internal/queue"
 	serializepb "github.com/suman7383/go-queue/internal/serialize"
@@ -17,46 +22,106 @@ import (
 
 type HTTPServer struct {
 	Registry *q.TopicRegistry
+
+	// Share of the data directory's filesystem that must be free for
+	// /readyz to pass (DefaultMinFreeDisk)
+	MinFreeDisk float64
+
+	server      *http.Server
+	cancelPolls context.CancelFunc // answers long polls on shutdown
 }
 
 func NewHttpServer(registry *q.TopicRegistry) *HTTPServer {
-	return &HTTPServer{Registry: registry}
+	s := &HTTPServer{Registry: registry, MinFreeDisk: DefaultMinFreeDisk}
+
+	mux := http.NewServeMux()
+	handle := func(route string, handler http.HandlerFunc) {
+		mux.HandleFunc(route, instrument(route, handler))
+	}
+	// Topic routes wait for topics to be recovered from disk
+	handleTopic := func(route string, handler http.HandlerFunc) {
+		handle(route, s.afterRecovery(handler))
+	}
+	handleTopic("/produce/", s.handleProduce)
+	handleTopic("/consume/", s.handleConsume)
+	handleTopic("/ack/", s.handleAck)
+	handleTopic("/nack/", s.handleNack)
+	handleTopic("/extend/", s.handleExtend)
+	handleTopic("/subscribe/", s.handleSubscribe)
+	handleTopic("/dlq/", s.handleDeadLetter)
+	handleTopic("/stats/", s.handleStats)
+	handleTopic("/topics", s.handleTopics)
+	handleTopic("/topics/", s.handleTopics)
+	handle("/metrics", s.handleMetrics)
+	handle("/healthz", s.handleHealthz)
+	handle("/readyz", s.handleReadyz)
+
+	// Every request context derives from base
+	base, cancel := context.WithCancel(context.Background())
+	s.cancelPolls = cancel
+	s.server = &http.Server{
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return base },
+	}
+
+	return s
 }
 
-func (s *HTTPServer) Start(addr string) {
-	http.HandleFunc("/produce/", s.handleProduce)
-	http.HandleFunc("/consume/", s.handleConsume)
-	http.HandleFunc("/ack/", s.handleAck)
+// Start serves on addr until Shutdown is called, then returns
+// http.ErrServerClosed
+func (s *HTTPServer) Start(addr string) error {
+	s.server.Addr = addr
 
 	log.Println("[HTTP] Server running at", addr)
-	http.ListenAndServe(addr, nil)
+	return s.server.ListenAndServe()
 }
 
+// Shutdown stops accepting requests and waits for the ones in progress
+// to finish, or for ctx to be done. Long-polling consumers get their
+// answer right away instead of waiting out their wait.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.cancelPolls()
+	return s.server.Shutdown(ctx)
+}
+
+// Route -> /produce/[TOPIC-NAME]
+// Answers {"ids": [...]} with the ID of each message, in the order they
+// were sent. A message whose dedup_key was seen before gets the ID it
+// was first published under.
 func (s *HTTPServer) handleProduce(w http.ResponseWriter, r *http.Request) {
 	topicName := strings.TrimPrefix(r.URL.Path, "/produce/")
-	topic := s.Registry.GetTopic(topicName)
-
-	if topic == nil {
-		s.Registry.CreateTopic(topicName)
+	if err := s.Registry.CreateTopic(topicName); err != nil {
+		sendCreateError(w, err)
+		return
 	}
 
-	message, err := extractMessage(r)
-
+	// Anything wrong with the body is the client's fault
+	r.Body = http.MaxBytesReader(w, r.Body, maxProduceBytes)
+	messages, err := extractMessages(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	topic = s.Registry.GetTopic(topicName)
-	_, err = topic.Enqueue(message)
+	topic := s.Registry.GetTopic(topicName)
+	ids, err := topic.EnqueueMessages(messages)
 	if err != nil {
 		http.Error(w, "Failed to enqueue message", http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Fprint(w, "OK\n")
+	if ids == nil {
+		ids = []int64{} // an empty batch, answered as [] rather than null
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]int64{"ids": ids})
 }
 
+// Route -> /consume/[TOPIC-NAME]?group=[GROUP-NAME]&wait=[DURATION]&max=[N]
+// Without a group the topic's default group is used, other groups must
+// be subscribed first (404 otherwise). With wait (eg: 20s)
+// the request blocks until a message arrives, the wait expires or the
+// client goes away. With max, up to N messages are returned as a list.
 func (s *HTTPServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 	topicName := strings.TrimPrefix(r.URL.Path, "/consume/")
 	topic := s.Registry.GetTopic(topicName)
@@ -65,28 +130,119 @@ func (s *HTTPServer) handleConsume(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	msg, ok := topic.Dequeue()
-	if !ok {
+	wait, err := parseWait(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	max, err := parseMax(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	group := r.URL.Query().Get("group")
+
+	var msgs []q.Message
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		msgs, err = topic.DequeueBatchWait(ctx, group, max)
+		cancel()
+	} else {
+		msgs, err = topic.DequeueBatch(group, max)
+	}
+	if errors.Is(err, q.ErrGroupNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if len(msgs) == 0 {
 		http.Error(w, q.ErrEmptyQueue.Error(), http.StatusNoContent)
 		return
 	}
 
-	encodeAndSendResponse(w, r, msg)
+	if r.URL.Query().Has("max") {
+		encodeAndSendBatchResponse(w, r, msgs)
+	} else {
+		encodeAndSendResponse(w, r, msgs[0])
+	}
 }
 
-// Route -> /ack/[TOPIC-NAME]/[TOPIC-ID]
+// Route -> /ack/[TOPIC-NAME]/[RECEIPT]?group=[GROUP-NAME]
+// The receipt is the one handed out with the message by /consume
 func (s *HTTPServer) handleAck(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 {
+	if len(parts) < 4 || parts[3] == "" {
 		http.Error(w, "Invalid URL", http.StatusBadRequest)
 		return
 	}
 
 	topicName := parts[2]
-	id, err := strconv.ParseInt(parts[3], 10, 64)
+	receipt := parts[3]
 
-	if err != nil {
-		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+	topic := s.Registry.GetTopic(topicName)
+	if topic == nil {
+		http.Error(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+
+	if err := topic.Acknowledge(r.URL.Query().Get("group"), receipt); err != nil {
+		sendSettleError(w, err)
+		return
+	}
+	fmt.Fprint(w, "OK\n")
+}
+
+// Route -> /nack/[TOPIC-NAME]/[RECEIPT]?group=[GROUP-NAME]&delay=[DURATION]
+// The message goes back to the group right away, or after delay (eg: 30s)
+func (s *HTTPServer) handleNack(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 4 || parts[3] == "" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+
+	topicName := parts[2]
+	receipt := parts[3]
+
+	var delay time.Duration
+	if v := r.URL.Query().Get("delay"); v != "" {
+		var err error
+		delay, err = time.ParseDuration(v)
+		if err != nil || delay < 0 {
+			http.Error(w, "Invalid delay", http.StatusBadRequest)
+			return
+		}
+	}
+
+	topic := s.Registry.GetTopic(topicName)
+	if topic == nil {
+		http.Error(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+
+	if err := topic.Nack(r.URL.Query().Get("group"), receipt, delay); err != nil {
+		sendSettleError(w, err)
+		return
+	}
+	fmt.Fprint(w, "OK\n")
+}
+
+// Route -> /extend/[TOPIC-NAME]/[RECEIPT]?group=[GROUP-NAME]&by=[DURATION]
+// Pushes the message's redelivery deadline forward, responds with the new one
+func (s *HTTPServer) handleExtend(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 4 || parts[3] == "" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+
+	topicName := parts[2]
+	receipt := parts[3]
+
+	by, err := time.ParseDuration(r.URL.Query().Get("by"))
+	if err != nil || by <= 0 {
+		http.Error(w, "Invalid by duration", http.StatusBadRequest)
 		return
 	}
 
@@ -96,48 +252,443 @@ func (s *HTTPServer) handleAck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	topic.Acknowledge(id)
+	deadline, err := topic.ExtendLease(r.URL.Query().Get("group"), receipt, by)
+	if err != nil {
+		sendSettleError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]time.Time{"deadline": deadline})
+}
+
+// Maps errors of ack, nack and extend to a status code.
+// A stale receipt gets its own (410) so consumers can tell they lost
+// the message to a redelivery.
+func sendSettleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, q.ErrInvalidReceipt):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, q.ErrStaleReceipt):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, q.ErrLeaseLimit):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	}
+}
+
+// Maps errors of creating a topic to a status code. Only a bad name is
+// the client's fault, the rest are I/O errors.
+func sendCreateError(w http.ResponseWriter, err error) {
+	if errors.Is(err, q.ErrInvalidTopicName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("[HTTP ERROR] creating topic: %v\n", err)
+	http.Error(w, "Failed to create topic", http.StatusInternalServerError)
+}
+
+// Route -> /subscribe/[TOPIC-NAME]/[GROUP-NAME]
+//
+//	POST   subscribe the group, it receives messages produced from now on
+//	DELETE unsubscribe the group and drop its pending messages
+func (s *HTTPServer) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 4 || parts[3] == "" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+
+	topicName, group := parts[2], parts[3]
+
+	switch r.Method {
+	case http.MethodPost:
+		if err := s.Registry.CreateTopic(topicName); err != nil {
+			sendCreateError(w, err)
+			return
+		}
+		s.Registry.GetTopic(topicName).Subscribe(group)
+	case http.MethodDelete:
+		topic := s.Registry.GetTopic(topicName)
+		if topic == nil {
+			http.Error(w, "Topic not found", http.StatusNotFound)
+			return
+		}
+		if !topic.Unsubscribe(group) {
+			http.Error(w, q.ErrGroupNotFound.Error(), http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	fmt.Fprint(w, "OK\n")
 }
 
+// Route -> /dlq/[TOPIC-NAME]
+//
+//	GET    /dlq/[TOPIC-NAME]?limit=N  inspect
+//	DELETE /dlq/[TOPIC-NAME]          purge
+//	POST   /dlq/[TOPIC-NAME]/redrive  move back to the original topic
+func (s *HTTPServer) handleDeadLetter(w http.ResponseWriter, r *http.Request) {
+	topicName := strings.TrimPrefix(r.URL.Path, "/dlq/")
+
+	if name, ok := strings.CutSuffix(topicName, "/redrive"); ok {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		moved, err := s.Registry.Redrive(name)
+		if errors.Is(err, q.ErrTopicNotFound) {
+			http.Error(w, "Dead-letter topic not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to redrive messages", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]int{"redriven": moved})
+		return
+	}
+
+	dlq := s.Registry.GetDeadLetterTopic(topicName)
+	if dlq == nil {
+		http.Error(w, "Dead-letter topic not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		limit := 0
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		json.NewEncoder(w).Encode(dlq.Pending(limit))
+	case http.MethodDelete:
+		json.NewEncoder(w).Encode(map[string]int{"purged": dlq.Purge()})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Route -> /stats/[TOPIC-NAME]
+func (s *HTTPServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	topic := s.Registry.GetTopic(strings.TrimPrefix(r.URL.Path, "/stats/"))
+	if topic == nil {
+		http.Error(w, "Topic not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(topic.Stats())
+}
+
+// Route -> /topics
+//
+//	GET    /topics                     list topic names
+//	GET    /topics/[TOPIC-NAME]        config, depths, next ID and WAL size
+//	PUT    /topics/[TOPIC-NAME]        create with a config, or update it
+//	DELETE /topics/[TOPIC-NAME]        delete the topic and its WAL files
+//	POST   /topics/[TOPIC-NAME]/purge  drop every pending message
+func (s *HTTPServer) handleTopics(w http.ResponseWriter, r *http.Request) {
+	topicName := strings.Trim(strings.TrimPrefix(r.URL.Path, "/topics"), "/")
+
+	if topicName == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		json.NewEncoder(w).Encode(s.Registry.Topics())
+		return
+	}
+
+	if name, ok := strings.CutSuffix(topicName, "/purge"); ok {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		topic := s.Registry.GetTopic(name)
+		if topic == nil {
+			http.Error(w, "Topic not found", http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]int{"purged": topic.Purge()})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		topic := s.Registry.GetTopic(topicName)
+		if topic == nil {
+			http.Error(w, "Topic not found", http.StatusNotFound)
+			return
+		}
+
+		json.NewEncoder(w).Encode(topic.Describe())
+	case http.MethodPut:
+		// Settings left out of the body keep their current value
+		config := s.Registry.TopicConfig(topicName)
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, "Invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		created, err := s.Registry.PutTopic(topicName, config)
+		switch {
+		case errors.Is(err, q.ErrInvalidConfig), errors.Is(err, q.ErrInvalidTopicName):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, q.ErrConfigFixed):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, "Failed to save topic config", http.StatusInternalServerError)
+			return
+		}
+
+		topic := s.Registry.GetTopic(topicName)
+		if topic == nil {
+			http.Error(w, "Topic not found", http.StatusNotFound) // deleted meanwhile
+			return
+		}
+
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(topic.Describe())
+	case http.MethodDelete:
+		err := s.Registry.DeleteTopic(topicName)
+		if errors.Is(err, q.ErrTopicNotFound) {
+			http.Error(w, "Topic not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete topic", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprint(w, "OK\n")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Longest a consume request may block waiting for a message
+const maxConsumeWait = 60 * time.Second
+
+// Parses the optional wait query parameter, capped at maxConsumeWait
+func parseWait(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("wait")
+	if v == "" {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(v)
+	if err != nil || wait < 0 {
+		return 0, errors.New("Invalid wait duration")
+	}
+
+	return min(wait, maxConsumeWait), nil
+}
+
+// Most messages a single consume request may return
+const maxConsumeBatch = 1000
+
+// Parses the optional max query parameter, 1 if absent
+func parseMax(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("max")
+	if v == "" {
+		return 1, nil
+	}
+
+	max, err := strconv.Atoi(v)
+	if err != nil || max < 1 || max > maxConsumeBatch {
+		return 0, fmt.Errorf("max must be between 1 and %d", maxConsumeBatch)
+	}
+
+	return max, nil
+}
+
+// Limits of a single produce request, it's published under the topic
+// lock and written to the WAL as one group
+const (
+	maxProduceBatch = 1000
+	maxProduceBytes = 16 << 20 // 16MB
+)
+
 // Checks for content-type and
-// extract message accordingly
-func extractMessage(r *http.Request) (string, error) {
+// extract messages accordingly.
+// A single message or a batch is accepted:
+//
+//	json:     {"message": "..."} or [{"message": "..."}, ...],
+//	          binary payloads go base64 encoded in "payload" instead
+//	protobuf: Produce, or ProduceBatch with
+//	          "Content-Type: application/x-protobuf; messageType=ProduceBatch"
+//
+// Each message may carry a delay (eg: "30s") or an RFC 3339 deliver_at
+// time before which it isn't delivered, and a ttl (eg: "10m") after
+// which it expires instead, overriding the topic's MessageTTL. A
+// dedup_key makes retried publishes of the same message a no-op.
+func extractMessages(r *http.Request) ([]q.Message, error) {
+	// Read raw bytes from request body
+	body, err := io.ReadAll(r.Body)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, fmt.Errorf("body over %d bytes", tooLarge.Limit)
+	}
+	if err != nil {
+		return nil, errors.New("failed to read request body")
+	}
+
+	var payloads []producePayload
+
 	// Check for protobuf
 	if isProtoRequest(r) {
-		// Read raw bytes from request body
-		body, err := io.ReadAll(r.Body)
-
-		if err != nil {
-			return "", errors.New("failed to read request body")
+		if !isProtoBatchRequest(r) {
+			var payload serializepb.Produce
+			if err := proto.Unmarshal(body, &payload); err != nil {
+				return nil, errors.New("failed to unmarshal protobuf")
+			}
+
+			payloads = append(payloads, fromProto(&payload))
+		} else {
+			var batch serializepb.ProduceBatch
+			if err := proto.Unmarshal(body, &batch); err != nil {
+				return nil, errors.New("failed to unmarshal protobuf")
+			}
+
+			for _, payload := range batch.Messages {
+				payloads = append(payloads, fromProto(payload))
+			}
 		}
+	} else if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		// Json batch
+		if err := json.Unmarshal(trimmed, &payloads); err != nil {
+			return nil, errors.New("Invalid body")
+		}
+	} else {
+		// Json
+		var p producePayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, errors.New("Invalid body")
+		}
+		payloads = append(payloads, p)
+	}
 
-		var payload serializepb.Produce
-		if err := proto.Unmarshal(body, &payload); err != nil {
-			return "", errors.New("failed to unmarshal protobuf")
+	if len(payloads) > maxProduceBatch {
+		return nil, fmt.Errorf("at most %d messages per request, got %d", maxProduceBatch, len(payloads))
+	}
+
+	now := time.Now()
+	messages := make([]q.Message, len(payloads))
+	for i, p := range payloads {
+		if messages[i], err = p.toMessage(now); err != nil {
+			return nil, err
 		}
+	}
 
-		return payload.Message, nil
+	return messages, nil
+}
+
+// One message as sent by a producer, with either a text message
+// or a binary payload
+type producePayload struct {
+	Message      string            `json:"message"`
+	Payload      []byte            `json:"payload,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	DedupKey     string            `json:"dedup_key,omitempty"`
+	Priority     int               `json:"priority,omitempty"`
+	MessageGroup string            `json:"message_group,omitempty"`
+	Delay        string            `json:"delay,omitempty"`
+	DeliverAt    string            `json:"deliver_at,omitempty"`
+	TTL          string            `json:"ttl,omitempty"`
+}
+
+func fromProto(p *serializepb.Produce) producePayload {
+	return producePayload{
+		Message:      p.GetMessage(),
+		Payload:      p.GetPayload(),
+		Headers:      p.GetHeaders(),
+		DedupKey:     p.GetDedupKey(),
+		Priority:     int(p.GetPriority()),
+		MessageGroup: p.GetMessageGroup(),
+		Delay:        p.GetDelay(),
+		DeliverAt:    p.GetDeliverAt(),
+		TTL:          p.GetTtl(),
 	}
+}
 
-	// Json
-	var payload struct {
-		Message string `json:"message"`
+func (p producePayload) toMessage(now time.Time) (q.Message, error) {
+	if p.Message != "" && len(p.Payload) > 0 {
+		return q.Message{}, errors.New("only one of message and payload can be set")
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		return "", errors.New("Invalid body")
+	msg := q.Message{
+		Payload:      p.Payload,
+		Headers:      p.Headers,
+		DedupKey:     p.DedupKey,
+		Priority:     p.Priority,
+		MessageGroup: p.MessageGroup,
+	}
+	if p.Message != "" {
+		msg.Payload = []byte(p.Message)
 	}
 
-	return payload.Message, nil
+	switch {
+	case p.Delay != "" && p.DeliverAt != "":
+		return q.Message{}, errors.New("only one of delay and deliver_at can be set")
+	case p.Delay != "":
+		delay, err := time.ParseDuration(p.Delay)
+		if err != nil || delay < 0 {
+			return q.Message{}, errors.New("invalid delay")
+		}
+		msg.DeliverAt = now.Add(delay)
+	case p.DeliverAt != "":
+		at, err := time.Parse(time.RFC3339Nano, p.DeliverAt)
+		if err != nil {
+			return q.Message{}, errors.New("invalid deliver_at, want RFC 3339")
+		}
+		msg.DeliverAt = at
+	}
+
+	if p.TTL != "" {
+		ttl, err := time.ParseDuration(p.TTL)
+		if err != nil || ttl <= 0 {
+			return q.Message{}, errors.New("invalid ttl")
+		}
+		msg.ExpiresAt = now.Add(ttl)
+	}
+
+	return msg, nil
 }
 
 // Checks if content-type is protobuf
 // then send response accordingly
 func encodeAndSendResponse(w http.ResponseWriter, r *http.Request, msg q.Message) {
+	sendResponse(w, r, serializepb.FromMessage(msg), msg)
+}
+
+// Same as encodeAndSendResponse for a list of messages
+func encodeAndSendBatchResponse(w http.ResponseWriter, r *http.Request, msgs []q.Message) {
+	sendResponse(w, r, serializepb.FromMessages(msgs), msgs)
+}
+
+// Writes msgpb if the client accepts protobuf, v as json otherwise
+func sendResponse(w http.ResponseWriter, r *http.Request, msgpb proto.Message, v any) {
 	// protobuf
 	if acceptProtoResponse(r) {
-		msgpb := serializepb.FromMessage(msg)
 		data, err := proto.Marshal(msgpb)
 
 		if err != nil {
@@ -150,7 +701,7 @@ func encodeAndSendResponse(w http.ResponseWriter, r *http.Request, msg q.Message
 		w.Write(data)
 	} else {
 		// json
-		json.NewEncoder(w).Encode(msg)
+		json.NewEncoder(w).Encode(v)
 	}
 }
 
@@ -160,6 +711,13 @@ func isProtoRequest(r *http.Request) bool {
 	return strings.Contains(r.Header.Get("Content-Type"), "application/x-protobuf")
 }
 
+// Checks if a protobuf body is a ProduceBatch
+// Header: "Content-Type: application/x-protobuf; messageType=ProduceBatch"
+func isProtoBatchRequest(r *http.Request) bool {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && params["messagetype"] == "ProduceBatch"
+}
+
 func acceptProtoResponse(r *http.Request) bool {
 	return strings.Contains(r.Header.Get("Accept"), "application/x-protobuf")
 }
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	q "github.com/suman7383/go-queue/internal/queue"
+)
+
+var testConfig = q.TopicConfig{
+	AckTimeout:         time.Hour,
+	MaxRetries:         3,
+	CheckpointInterval: -1,
+}
+
+// Serves a registry recovered from an empty data directory
+func newTestServer(t *testing.T) *HTTPServer {
+	t.Helper()
+
+	t.Chdir(t.TempDir())
+	registry := q.NewTopicRegistry(testConfig)
+	registry.LoadTopicFromDisk(testConfig)
+	t.Cleanup(registry.Close)
+
+	return NewHttpServer(registry)
+}
+
+// Sends a request with a JSON body (if not empty) and returns the response
+func do(t *testing.T, s *HTTPServer, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestProduceRejectsInvalidMessages(t *testing.T) {
+	s := newTestServer(t)
+
+	bodies := []string{
+		`{"message": "a", "delay": "soon"}`,
+		`{"message": "a", "delay": "-1s"}`,
+		`{"message": "a", "deliver_at": "tomorrow"}`,
+		`{"message": "a", "delay": "1s", "deliver_at": "2030-01-01T00:00:00Z"}`,
+		`{"message": "a", "ttl": "forever"}`,
+		`{"message": "a", "ttl": "0s"}`,
+		`{"message": "a", "payload": "Yg=="}`,
+		`[{"message": "a"}, {"message": "b", "ttl": "x"}]`,
+		`not json`,
+	}
+	for _, body := range bodies {
+		if rec := do(t, s, http.MethodPost, "/produce/orders", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", body, rec.Code)
+		}
+	}
+
+	// Nothing of a rejected batch was published
+	if stats := s.Registry.GetTopic("orders").Stats(); stats.Pending != 0 {
+		t.Fatalf("got %d pending messages, want none", stats.Pending)
+	}
+}
+
+// Produces body to topic and returns the IDs answered
+func produce(t *testing.T, s *HTTPServer, topic, body string) []int64 {
+	t.Helper()
+
+	rec := do(t, s, http.MethodPost, "/produce/"+topic, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("produce %s: got %d %s", body, rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("produce answered %q: %v", rec.Body, err)
+	}
+	return resp.IDs
+}
+
+func TestBatchProduceReturnsIDsInOrder(t *testing.T) {
+	s := newTestServer(t)
+
+	if ids := produce(t, s, "orders", `{"message": "a"}`); !slices.Equal(ids, []int64{1}) {
+		t.Fatalf("got IDs %v, want [1]", ids)
+	}
+	ids := produce(t, s, "orders", `[{"message": "b"}, {"message": "c", "delay": "1h"}, {"message": "d"}]`)
+	if !slices.Equal(ids, []int64{2, 3, 4}) {
+		t.Fatalf("got IDs %v, want [2 3 4]", ids)
+	}
+}
+
+func TestProduceLimits(t *testing.T) {
+	s := newTestServer(t)
+
+	if rec := do(t, s, http.MethodPost, "/produce/orders", "[]"); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"ids":[]}` {
+		t.Fatalf("empty batch: got %d %s, want 200 {\"ids\":[]}", rec.Code, rec.Body)
+	}
+
+	batch := "[" + strings.Repeat(`{"message": "a"},`, maxProduceBatch) + `{"message": "a"}]`
+	if rec := do(t, s, http.MethodPost, "/produce/orders", batch); rec.Code != http.StatusBadRequest {
+		t.Fatalf("batch of %d: got %d %s, want 400", maxProduceBatch+1, rec.Code, rec.Body)
+	}
+
+	body := `{"message": "` + strings.Repeat("a", maxProduceBytes) + `"}`
+	if rec := do(t, s, http.MethodPost, "/produce/orders", body); rec.Code != http.StatusBadRequest {
+		t.Fatalf("body over %d bytes: got %d, want 400", maxProduceBytes, rec.Code)
+	}
+
+	if stats := s.Registry.GetTopic("orders").Stats(); stats.Pending != 0 {
+		t.Fatalf("got %d pending messages, want 0", stats.Pending)
+	}
+}
+
+func TestDuplicateDedupKeyReturnsOriginalID(t *testing.T) {
+	s := newTestServer(t)
+
+	first := produce(t, s, "orders", `{"message": "a", "dedup_key": "order-1"}`)
+	retried := produce(t, s, "orders", `[{"message": "b"}, {"message": "a", "dedup_key": "order-1"}]`)
+	if !slices.Equal(first, []int64{1}) || !slices.Equal(retried, []int64{2, 1}) {
+		t.Fatalf("got IDs %v then %v, want [1] then [2 1]", first, retried)
+	}
+	if stats := s.Registry.GetTopic("orders").Stats(); stats.Pending != 2 {
+		t.Fatalf("got %d pending messages, want 2", stats.Pending)
+	}
+}
+
+// Consumes one message of topic and nacks it
+func consumeAndNack(t *testing.T, s *HTTPServer, topic string) {
+	t.Helper()
+
+	rec := do(t, s, http.MethodGet, "/consume/"+topic, "")
+	var msg q.Message
+	if err := json.Unmarshal(rec.Body.Bytes(), &msg); err != nil || msg.Receipt == "" {
+		t.Fatalf("consume answered %d %q", rec.Code, rec.Body)
+	}
+	if rec := do(t, s, http.MethodPost, "/nack/"+topic+"/"+msg.Receipt, ""); rec.Code != http.StatusOK {
+		t.Fatalf("nack answered %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestDeadLetterEndpoints(t *testing.T) {
+	s := newTestServer(t)
+
+	if rec := do(t, s, http.MethodPut, "/topics/orders", `{"max_retries": 0}`); rec.Code != http.StatusCreated {
+		t.Fatalf("put topic answered %d %q", rec.Code, rec.Body)
+	}
+	produce(t, s, "orders", `[{"message": "a"}, {"message": "b"}]`)
+	consumeAndNack(t, s, "orders")
+
+	// Inspect
+	rec := do(t, s, http.MethodGet, "/dlq/orders", "")
+	var dead []q.Message
+	if err := json.Unmarshal(rec.Body.Bytes(), &dead); err != nil {
+		t.Fatalf("inspect answered %d %q", rec.Code, rec.Body)
+	}
+	if len(dead) != 1 || string(dead[0].Payload) != "a" || dead[0].DeadLetter == nil || dead[0].DeadLetter.Topic != "orders" {
+		t.Fatalf("got dead letters %+v", dead)
+	}
+
+	// Redrive puts it back behind the pending message
+	rec = do(t, s, http.MethodPost, "/dlq/orders/redrive", "")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"redriven":1}` {
+		t.Fatalf("redrive answered %d %q", rec.Code, rec.Body)
+	}
+	consumeAndNack(t, s, "orders") // b
+	consumeAndNack(t, s, "orders") // a, redriven
+
+	// Purge
+	rec = do(t, s, http.MethodDelete, "/dlq/orders", "")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"purged":2}` {
+		t.Fatalf("purge answered %d %q", rec.Code, rec.Body)
+	}
+	if rec := do(t, s, http.MethodGet, "/dlq/orders", ""); strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Fatalf("got %q after purge", rec.Body)
+	}
+
+	for _, path := range []string{"/dlq/payments", "/dlq/payments/redrive"} {
+		method := http.MethodGet
+		if strings.HasSuffix(path, "/redrive") {
+			method = http.MethodPost
+		}
+		if rec := do(t, s, method, path, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s: got %d, want 404", method, path, rec.Code)
+		}
+	}
+}
+
+func TestTopicPurgeEndpoint(t *testing.T) {
+	s := newTestServer(t)
+
+	produce(t, s, "orders", `[{"message": "a"}, {"message": "b"}, {"message": "c"}]`)
+	do(t, s, http.MethodGet, "/consume/orders", "")
+
+	rec := do(t, s, http.MethodPost, "/topics/orders/purge", "")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"purged":2}` {
+		t.Fatalf("purge answered %d %q", rec.Code, rec.Body)
+	}
+	if stats := s.Registry.GetTopic("orders").Stats(); stats.Pending != 0 || stats.InFlight != 1 {
+		t.Fatalf("got %+v, want only the in-flight message left", stats)
+	}
+	if rec := do(t, s, http.MethodPost, "/topics/payments/purge", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("purging a missing topic answered %d", rec.Code)
+	}
+}
+
+func TestMetricsLabelUnknownMethodsOther(t *testing.T) {
+	s := newTestServer(t)
+
+	do(t, s, "BREW", "/healthz", "")
+	rec := do(t, s, http.MethodGet, "/metrics", "")
+
+	body := rec.Body.String()
+	if strings.Contains(body, `method="BREW"`) || !strings.Contains(body, `method="other"`) {
+		t.Fatalf("got metrics:\n%s", body)
+	}
+}
+
+func TestPutTopicRejectsDurationsWithoutUnit(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, body := range []string{`{"ack_timeout": 30}`, `{"retry": {"kind": "fixed", "delay": 5}}`} {
+		if rec := do(t, s, http.MethodPut, "/topics/orders", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("put %s answered %d %q", body, rec.Code, rec.Body)
+		}
+	}
+	if rec := do(t, s, http.MethodPut, "/topics/orders", `{"ack_timeout": "30s", "max_lease": 0}`); rec.Code != http.StatusCreated {
+		t.Fatalf("put topic answered %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestConsumeUnknownGroupIsNotFound(t *testing.T) {
+	s := newTestServer(t)
+	produce(t, s, "orders", `{"message": "a"}`)
+
+	if rec := do(t, s, http.MethodGet, "/consume/orders?group=biling", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("consume answered %d %q", rec.Code, rec.Body)
+	}
+	if groups := s.Registry.GetTopic("orders").Groups(); !slices.Equal(groups, []string{q.DefaultGroup}) {
+		t.Fatalf("got groups %v, consuming subscribed a group", groups)
+	}
+
+	do(t, s, http.MethodPost, "/subscribe/orders/billing", "")
+	produce(t, s, "orders", `{"message": "b"}`)
+	if rec := do(t, s, http.MethodGet, "/consume/orders?group=billing", ""); rec.Code != http.StatusOK {
+		t.Fatalf("consume answered %d %q", rec.Code, rec.Body)
+	}
+}
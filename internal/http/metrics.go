@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/suman7383/go-queue/internal/metrics"
+)
+
+var requestSeconds = metrics.NewHistogram("goqueue_http_request_duration_seconds", "HTTP request latency, long polls included.",
+	metrics.DefBuckets, "route", "method", "code")
+
+// statusRecorder remembers the status code a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// instrument records the latency of handler under route
+func instrument(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+
+		requestSeconds.Observe(time.Since(start).Seconds(), route, methodLabel(r.Method), strconv.Itoa(recorder.status))
+	}
+}
+
+// methodLabel maps methods to a fixed set of labels. Clients can send any
+// method, each would be a new series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete:
+		return method
+	}
+	return "other"
+}
+
+// Route -> /metrics
+// Prometheus text format
+func (s *HTTPServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics.Default.Handler(s.Registry.CollectMetrics).ServeHTTP(w, r)
+}
@@ -0,0 +1,295 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics and writes them in the Prometheus text
+// exposition format, in the order they were registered
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*Vec
+}
+
+// Default is the registry the package level New* functions register with
+var Default = &Registry{}
+
+// Vec is a metric with one series per combination of label values
+type Vec struct {
+	name    string
+	help    string
+	kind    string // "counter" | "gauge" | "histogram"
+	labels  []string
+	buckets []float64 // histogram upper bounds, ascending, without +Inf
+
+	mu     sync.Mutex
+	series map[string]*series // by joined label values
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // counter or gauge value, histogram sum
+	counts      []uint64 // histogram observations per bucket, then +Inf
+}
+
+// Counter only goes up
+type Counter struct{ *Vec }
+
+// Gauge goes up and down
+type Gauge struct{ *Vec }
+
+// Histogram counts observations in buckets
+type Histogram struct{ *Vec }
+
+// Default buckets for latencies in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count buckets from start, each factor
+// times the previous one
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+func (r *Registry) register(v *Vec) *Vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range r.metrics {
+		if m.name == v.name {
+			panic("metrics: " + v.name + " registered twice")
+		}
+	}
+	v.series = make(map[string]*series)
+	r.metrics = append(r.metrics, v)
+	return v
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) Counter {
+	return Counter{r.register(&Vec{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) Gauge {
+	return Gauge{r.register(&Vec{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds,
+// in ascending order. A +Inf bucket is always added.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) Histogram {
+	return Histogram{r.register(&Vec{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+func NewCounter(name, help string, labels ...string) Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+func NewGauge(name, help string, labels ...string) Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// Returns the series of labelValues, creating it. Callers hold v.mu
+func (v *Vec) with(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if v.kind == "histogram" {
+			s.counts = make([]uint64, len(v.buckets)+1)
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// DeleteLabel drops every series whose label is value, eg: the series
+// of a deleted topic
+func (v *Vec) DeleteLabel(label, value string) {
+	i := slices.Index(v.labels, label)
+	if i < 0 {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for key, s := range v.series {
+		if s.labelValues[i] == value {
+			delete(v.series, key)
+		}
+	}
+}
+
+// Reset drops every series
+func (v *Vec) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	clear(v.series)
+}
+
+func (c Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter by delta, which must not be negative
+func (c Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.name + " decreased")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.with(labelValues).value += delta
+}
+
+func (g Gauge) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.with(labelValues).value = value
+}
+
+func (h Histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.with(labelValues)
+	// Counts are per bucket here, they are summed up when written
+	s.counts[bucketIndex(h.buckets, value)]++
+	s.value += value
+}
+
+// Index of the first bucket holding value, len(buckets) for +Inf
+func bucketIndex(buckets []float64, value float64) int {
+	i, _ := slices.BinarySearch(buckets, value)
+	return i
+}
+
+// WriteText writes every metric in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, v := range metrics {
+		v.writeText(buf)
+	}
+	return buf.Flush()
+}
+
+func (v *Vec) writeText(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		labels := formatLabels(v.labels, s.labelValues)
+
+		if v.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", v.name, labels, formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(v.buckets) {
+				le = formatFloat(v.buckets[i])
+			}
+			bucketLabels := formatLabels(append(slices.Clone(v.labels), "le"), append(slices.Clone(s.labelValues), le))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, bucketLabels, cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, labels, formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, labels, cumulative)
+	}
+}
+
+func sortedKeys(m map[string]*series) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// eg: {topic="orders",group="default"}, "" without labels
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler serves the registry's metrics, refreshing them with collect
+// first if it isn't nil
+func (r *Registry) Handler(collect func()) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if collect != nil {
+			collect()
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := &Registry{}
+	requests := r.NewCounter("requests_total", "Requests served.", "route", "code")
+	depth := r.NewGauge("depth", "Queue depth.")
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+
+	requests.Inc("/a", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc(`/"b"`, "500")
+	depth.Set(7)
+	latency.Observe(0.05, "/a")
+	latency.Observe(1, "/a")
+	latency.Observe(3, "/a")
+
+	var out strings.Builder
+	if err := r.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/\"b\"",code="500"} 1
+requests_total{route="/a",code="200"} 3
+# HELP depth Queue depth.
+# TYPE depth gauge
+depth 7
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 1
+latency_seconds_bucket{route="/a",le="1"} 2
+latency_seconds_bucket{route="/a",le="+Inf"} 3
+latency_seconds_sum{route="/a"} 4.05
+latency_seconds_count{route="/a"} 3
+`
+	if out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	// Series of a label value can be dropped
+	requests.DeleteLabel("route", "/a")
+	out.Reset()
+	r.WriteText(&out)
+	if strings.Contains(out.String(), `requests_total{route="/a"`) {
+		t.Fatalf("deleted series still written:\n%s", out.String())
+	}
+}
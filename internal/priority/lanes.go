@@ -0,0 +1,126 @@
+package priority
+
+import (
+	"sync"
+
+	"github.com/suman7383/go-queue/internal/ringbuffer"
+)
+
+// Lanes is a queue with one FIFO lane per priority level. Dequeue picks
+// lanes by smooth weighted round robin, so urgent lanes are served more
+// often without starving the others. Order is kept within a lane.
+type Lanes[T any] struct {
+	lanes    []*ringbuffer.RingBuffer[T]
+	weights  []int
+	current  []int // round robin credit of each lane
+	priority func(T) int
+	mu       sync.Mutex
+}
+
+// NewLanes creates len(weights) lanes, lane i holding items of priority i
+// and getting weights[i] turns per round. priority maps an item to its
+// lane, values out of range go to the nearest lane.
+func NewLanes[T any](weights []int, capacity int64, priority func(T) int) *Lanes[T] {
+	l := &Lanes[T]{
+		lanes:    make([]*ringbuffer.RingBuffer[T], len(weights)),
+		weights:  weights,
+		current:  make([]int, len(weights)),
+		priority: priority,
+	}
+	for i := range l.lanes {
+		l.lanes[i] = ringbuffer.NewRingBuffer[T](capacity)
+	}
+
+	return l
+}
+
+// DefaultWeights doubles the weight of each level over the one below
+func DefaultWeights(levels int) []int {
+	weights := make([]int, levels)
+	for i := range weights {
+		weights[i] = 1 << i
+	}
+	return weights
+}
+
+func (l *Lanes[T]) lane(item T) int {
+	return min(max(l.priority(item), 0), len(l.lanes)-1)
+}
+
+// insert an element at the tail of its lane.
+// Returns the total size
+func (l *Lanes[T]) Enqueue(item T) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lanes[l.lane(item)].Enqueue(item)
+	return l.size()
+}
+
+func (l *Lanes[T]) Dequeue() (T, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Every non-empty lane earns its weight, the richest one is served
+	// and pays the total back. Ties go to the higher priority.
+	pick, total := -1, 0
+	for i := len(l.lanes) - 1; i >= 0; i-- {
+		if l.lanes[i].Size() == 0 {
+			l.current[i] = 0 // no credit saved up while idle
+			continue
+		}
+
+		l.current[i] += l.weights[i]
+		total += l.weights[i]
+		if pick < 0 || l.current[i] > l.current[pick] {
+			pick = i
+		}
+	}
+
+	if pick < 0 {
+		var t T
+		return t, false
+	}
+
+	l.current[pick] -= total
+	return l.lanes[pick].Dequeue()
+}
+
+// Returns a copy of the queued elements, highest priority lane first
+func (l *Lanes[T]) Items() []T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	items := make([]T, 0, l.size())
+	for i := len(l.lanes) - 1; i >= 0; i-- {
+		items = append(items, l.lanes[i].Items()...)
+	}
+
+	return items
+}
+
+func (l *Lanes[T]) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.size()
+}
+
+func (l *Lanes[T]) Cap() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var cap int64
+	for _, lane := range l.lanes {
+		cap += lane.Cap()
+	}
+	return cap
+}
+
+func (l *Lanes[T]) size() int64 {
+	var size int64
+	for _, lane := range l.lanes {
+		size += lane.Size()
+	}
+	return size
+}
@@ -0,0 +1,27 @@
+package queue
+
+// TopicInfo describes a topic for the admin API
+type TopicInfo struct {
+	Name     string      `json:"name"`
+	Config   TopicConfig `json:"config"`
+	NextID   int64       `json:"next_id"`
+	WALBytes int64       `json:"wal_bytes"` // segments and snapshots on disk
+	TopicStats
+}
+
+// Describe returns the topic's config along with its current counts
+func (t *Topic) Describe() TopicInfo {
+	stats := t.Stats()
+
+	t.mu.Lock()
+	config, nextID := t.config, t.nextID
+	t.mu.Unlock()
+
+	return TopicInfo{
+		Name:       t.Name,
+		Config:     config,
+		NextID:     nextID,
+		WALBytes:   t.wal.Size(),
+		TopicStats: stats,
+	}
+}
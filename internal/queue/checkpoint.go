@@ -0,0 +1,248 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	snapshotExt = ".snap"
+
+	DefaultCheckpointInterval = time.Minute
+)
+
+var (
+	snapshotMagic = [4]byte{'G', 'Q', 'S', 'P'}
+
+	errBadSnapshot = errors.New("not a snapshot file")
+)
+
+// snapshot is the live state of a topic at the point its WAL was sealed.
+// It starts with a header (magic, base, nextID, CRC32C of base+nextID)
+// followed by framed records.
+// The state is stored as a compacted log: per consumer group a "subscribe"
+// entry, one "enqueue" entry per pending message and one "deliver" entry
+// per in-flight message, so recovery can feed it through the same replay
+// logic as the WAL itself.
+type snapshot struct {
+	base    int64 // first segment NOT covered by this snapshot
+	nextID  int64
+	entries []LogEntry
+}
+
+// Snapshots are named after their base segment
+// eg: data/orders/00000000000000000007.snap
+func snapshotPath(dir string, base int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, snapshotExt))
+}
+
+// writeSnapshot atomically replaces the snapshot for snap.base
+func writeSnapshot(dir string, snap snapshot) error {
+	path := snapshotPath(dir, snap.base)
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		writer := bufio.NewWriter(file)
+		if _, err := writer.Write(snapshotMagic[:]); err != nil {
+			return err
+		}
+		if _, err := writer.Write(snapshotHeader(snap)); err != nil {
+			return err
+		}
+		for _, entry := range snap.entries {
+			if err := writeRecord(writer, entry); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		return file.Sync()
+	}()
+
+	file.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func readSnapshot(path string) (snapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return snapshot{}, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(reader, magic[:]); err != nil || magic != snapshotMagic {
+		return snapshot{}, errBadSnapshot
+	}
+
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return snapshot{}, err
+	}
+
+	snap := snapshot{
+		base:   int64(binary.LittleEndian.Uint64(header[0:8])),
+		nextID: int64(binary.LittleEndian.Uint64(header[8:16])),
+	}
+	if !bytes.Equal(snapshotHeader(snap), header) {
+		return snapshot{}, errChecksum
+	}
+
+	records := &recordReader{r: reader, size: -1}
+	for {
+		entry, _, err := records.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Snapshots are renamed into place only once complete,
+			// so any bad record makes the whole snapshot unusable
+			return snapshot{}, err
+		}
+		snap.entries = append(snap.entries, entry)
+	}
+
+	return snap, nil
+}
+
+// Encodes base and nextID followed by their checksum
+func snapshotHeader(snap snapshot) []byte {
+	header := make([]byte, 20)
+	binary.LittleEndian.PutUint64(header[0:8], uint64(snap.base))
+	binary.LittleEndian.PutUint64(header[8:16], uint64(snap.nextID))
+	binary.LittleEndian.PutUint32(header[16:20], crc32.Checksum(header[:16], crcTable))
+	return header
+}
+
+// loadLatestSnapshot returns the newest readable snapshot in dir
+func loadLatestSnapshot(dir string) (snapshot, bool) {
+	bases, err := listNumbered(dir, snapshotExt)
+	if err != nil {
+		return snapshot{}, false
+	}
+
+	for i := len(bases) - 1; i >= 0; i-- {
+		path := snapshotPath(dir, bases[i])
+		snap, err := readSnapshot(path)
+		if err != nil {
+			log.Printf("[Recovery] skipping snapshot %s: %v\n", path, err)
+			continue
+		}
+		return snap, true
+	}
+
+	return snapshot{}, false
+}
+
+// removeSnapshotsBefore deletes the snapshots superseded by base
+func removeSnapshotsBefore(dir string, base int64) error {
+	bases, err := listNumbered(dir, snapshotExt)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range bases {
+		if b >= base {
+			break
+		}
+		if err := os.Remove(snapshotPath(dir, b)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checkpoint writes a snapshot of the topic's live state and deletes
+// the WAL segments and older snapshots it covers.
+func (t *Topic) Checkpoint() error {
+	t.checkpointMu.Lock()
+	defer t.checkpointMu.Unlock()
+
+	// Wait for group-commit publishes to queue their messages, or they
+	// would be in the sealed segments but not in the snapshot
+	t.publishMu.Lock()
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		t.publishMu.Unlock()
+		return ErrTopicClosed
+	}
+	snap := snapshot{nextID: t.nextID}
+	// Remembered keys outlive the messages they were published with
+	t.dedup.evict(time.Now())
+	for _, k := range t.dedup.order {
+		snap.entries = append(snap.entries, LogEntry{Type: "dedup", Message: Message{ID: k.id, DedupKey: k.key, EnqueuedAt: k.at}})
+	}
+	for _, name := range t.groupNames() {
+		g := t.groups[name]
+		snap.entries = append(snap.entries, LogEntry{Type: "subscribe", Group: name, Message: Message{ID: g.cursor}})
+		for _, msg := range g.messages.Items() {
+			snap.entries = append(snap.entries, LogEntry{Type: "enqueue", Group: name, Message: msg})
+		}
+		for _, msg := range g.heldMessages() {
+			snap.entries = append(snap.entries, LogEntry{Type: "enqueue", Group: name, Message: msg})
+		}
+		for _, d := range g.delayed {
+			snap.entries = append(snap.entries, LogEntry{Type: "enqueue", Group: name, Message: d.msg, DueAt: d.due})
+		}
+		for _, id := range sortedIDs(g.inFlight) {
+			snap.entries = append(snap.entries, LogEntry{Type: "deliver", Group: name, Message: g.inFlight[id], DueAt: g.leases[id]})
+		}
+	}
+	// Recovery assumes the default group unless told otherwise
+	if _, ok := t.groups[DefaultGroup]; !ok {
+		snap.entries = append(snap.entries, LogEntry{Type: "unsubscribe", Group: DefaultGroup})
+	}
+	// Appends are blocked by t.mu, so the sealed segments hold exactly
+	// the history that produced the state captured above
+	base, err := t.wal.Seal()
+	t.mu.Unlock()
+	t.publishMu.Unlock()
+	if err != nil {
+		// The active segment holds history the snapshot covers
+		return err
+	}
+	snap.base = base
+
+	if snap.base == t.lastSnapshot {
+		return nil // nothing written since the last checkpoint
+	}
+
+	if err := writeSnapshot(t.wal.dir, snap); err != nil {
+		return err
+	}
+	t.lastSnapshot = snap.base
+
+	if err := t.wal.RemoveSegmentsBefore(snap.base); err != nil {
+		return err
+	}
+	if err := removeSnapshotsBefore(t.wal.dir, snap.base); err != nil {
+		return err
+	}
+
+	log.Printf("[Checkpoint] Topic: %s | snapshot at segment %d (%d live messages)\n", t.Name, snap.base, len(snap.entries))
+	return nil
+}
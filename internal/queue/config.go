@@ -0,0 +1,372 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A topic's own config is kept next to its WAL.
+// eg: data/orders/topic.json
+const metaFile = "topic.json"
+
+func metaPath(dir string) string {
+	return filepath.Join(dir, metaFile)
+}
+
+// Topic names are used as directory names, which most filesystems keep
+// to 255 bytes. Room is left for the suffix of the dead-letter topic.
+const maxTopicNameLen = 255 - len(deadLetterSuffix)
+
+// Topic names are used as directory names, keep them to one path element
+func validateTopicName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w: %q", ErrInvalidTopicName, name)
+	}
+	if len(strings.TrimSuffix(name, deadLetterSuffix)) > maxTopicNameLen {
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidTopicName, maxTopicNameLen)
+	}
+	return nil
+}
+
+// duration is a time.Duration written as a string in JSON, eg: "1m30s".
+// Plain numbers other than 0 are rejected, 30 surely doesn't mean 30ns.
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = duration(parsed)
+	case float64:
+		if v != 0 {
+			return fmt.Errorf("duration %s needs a unit, eg: \"%ss\"", data, data)
+		}
+		*d = 0
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	return nil
+}
+
+// topicConfigJSON is TopicConfig as written in JSON. Fields missing from
+// the input keep their current value, so a partial config updates
+// only what it names. Keep in sync with TopicConfig.
+type topicConfigJSON struct {
+	AckTimeout         duration    `json:"ack_timeout"`
+	MaxRetries         int         `json:"max_retries"`
+	Capacity           int64       `json:"capacity"`
+	Retry              RetryPolicy `json:"retry"`
+	MaxLease           duration    `json:"max_lease"`
+	SegmentMaxBytes    int64       `json:"segment_max_bytes"`
+	SegmentMaxAge      duration    `json:"segment_max_age"`
+	CheckpointInterval duration    `json:"checkpoint_interval"`
+	DeadLetterTopic    string      `json:"dead_letter_topic"`
+	MessageTTL         duration    `json:"message_ttl"`
+	DeadLetterExpired  bool        `json:"dead_letter_expired"`
+	DedupWindow        duration    `json:"dedup_window"`
+	DedupMaxKeys       int         `json:"dedup_max_keys"`
+	PriorityLevels     int         `json:"priority_levels"`
+	PriorityWeights    []int       `json:"priority_weights"`
+	Durability         Durability  `json:"durability"`
+	SyncInterval       duration    `json:"sync_interval"`
+	WALChannelDepth    int         `json:"wal_channel_depth"`
+	WALBatchSize       int         `json:"wal_batch_size"`
+	WALFlushInterval   duration    `json:"wal_flush_interval"`
+}
+
+func (c TopicConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(topicConfigJSON{
+		AckTimeout:         duration(c.AckTimeout),
+		MaxRetries:         c.MaxRetries,
+		Capacity:           c.Capacity,
+		Retry:              c.Retry,
+		MaxLease:           duration(c.MaxLease),
+		SegmentMaxBytes:    c.SegmentMaxBytes,
+		SegmentMaxAge:      duration(c.SegmentMaxAge),
+		CheckpointInterval: duration(c.CheckpointInterval),
+		DeadLetterTopic:    c.DeadLetterTopic,
+		MessageTTL:         duration(c.MessageTTL),
+		DeadLetterExpired:  c.DeadLetterExpired,
+		DedupWindow:        duration(c.DedupWindow),
+		DedupMaxKeys:       c.DedupMaxKeys,
+		PriorityLevels:     c.PriorityLevels,
+		PriorityWeights:    c.PriorityWeights,
+		Durability:         c.Durability,
+		SyncInterval:       duration(c.SyncInterval),
+		WALChannelDepth:    c.WALChannelDepth,
+		WALBatchSize:       c.WALBatchSize,
+		WALFlushInterval:   duration(c.WALFlushInterval),
+	})
+}
+
+// UnmarshalJSON overlays data on c. Unknown keys are rejected.
+func (c *TopicConfig) UnmarshalJSON(data []byte) error {
+	var j topicConfigJSON
+	current, err := c.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(current, &j); err != nil {
+		return err
+	}
+
+	if err := decodeStrict(data, &j); err != nil {
+		return err
+	}
+
+	*c = TopicConfig{
+		AckTimeout:         time.Duration(j.AckTimeout),
+		MaxRetries:         j.MaxRetries,
+		Capacity:           j.Capacity,
+		Retry:              j.Retry,
+		MaxLease:           time.Duration(j.MaxLease),
+		SegmentMaxBytes:    j.SegmentMaxBytes,
+		SegmentMaxAge:      time.Duration(j.SegmentMaxAge),
+		CheckpointInterval: time.Duration(j.CheckpointInterval),
+		DeadLetterTopic:    j.DeadLetterTopic,
+		MessageTTL:         time.Duration(j.MessageTTL),
+		DeadLetterExpired:  j.DeadLetterExpired,
+		DedupWindow:        time.Duration(j.DedupWindow),
+		DedupMaxKeys:       j.DedupMaxKeys,
+		PriorityLevels:     j.PriorityLevels,
+		PriorityWeights:    j.PriorityWeights,
+		Durability:         j.Durability,
+		SyncInterval:       time.Duration(j.SyncInterval),
+		WALChannelDepth:    j.WALChannelDepth,
+		WALBatchSize:       j.WALBatchSize,
+		WALFlushInterval:   time.Duration(j.WALFlushInterval),
+	}
+	return nil
+}
+
+// retryPolicyJSON is RetryPolicy as written in JSON
+type retryPolicyJSON struct {
+	Kind     RetryKind  `json:"kind"`
+	Delay    duration   `json:"delay"`
+	MaxDelay duration   `json:"max_delay"`
+	Jitter   float64    `json:"jitter"`
+	Schedule []duration `json:"schedule"`
+}
+
+func (p RetryPolicy) MarshalJSON() ([]byte, error) {
+	j := retryPolicyJSON{
+		Kind:     p.Kind,
+		Delay:    duration(p.Delay),
+		MaxDelay: duration(p.MaxDelay),
+		Jitter:   p.Jitter,
+	}
+	for _, d := range p.Schedule {
+		j.Schedule = append(j.Schedule, duration(d))
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON overlays data on p. Unknown keys are rejected.
+func (p *RetryPolicy) UnmarshalJSON(data []byte) error {
+	var j retryPolicyJSON
+	current, err := p.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(current, &j); err != nil {
+		return err
+	}
+
+	if err := decodeStrict(data, &j); err != nil {
+		return err
+	}
+
+	*p = RetryPolicy{
+		Kind:     j.Kind,
+		Delay:    time.Duration(j.Delay),
+		MaxDelay: time.Duration(j.MaxDelay),
+		Jitter:   j.Jitter,
+	}
+	for _, d := range j.Schedule {
+		p.Schedule = append(p.Schedule, time.Duration(d))
+	}
+	return nil
+}
+
+// Decodes data into v, failing on keys v doesn't have
+func decodeStrict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// Validate reports every setting of c that is out of range
+func (c TopicConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.AckTimeout > 0, "ack_timeout must be positive, got %v", c.AckTimeout)
+	check(c.MaxRetries >= 0, "max_retries can't be negative, got %d", c.MaxRetries)
+	check(c.DeadLetterTopic == "" || validateTopicName(c.DeadLetterTopic) == nil,
+		"dead_letter_topic must be a valid topic name, got %q", c.DeadLetterTopic)
+	check(c.Capacity >= 0 && c.Capacity <= MaxCapacity, "capacity must be between 0 and %d, got %d", MaxCapacity, c.Capacity)
+	check(slices.Contains([]RetryKind{"", RetryFixed, RetryExponential, RetrySchedule}, c.Retry.Kind),
+		"retry.kind must be %q, %q or %q, got %q", RetryFixed, RetryExponential, RetrySchedule, c.Retry.Kind)
+	check(c.Retry.Delay >= 0, "retry.delay can't be negative, got %v", c.Retry.Delay)
+	check(c.Retry.MaxDelay >= 0, "retry.max_delay can't be negative, got %v", c.Retry.MaxDelay)
+	check(c.Retry.Jitter >= 0 && c.Retry.Jitter <= 1, "retry.jitter must be between 0 and 1, got %v", c.Retry.Jitter)
+	for _, d := range c.Retry.Schedule {
+		check(d >= 0, "retry.schedule can't hold negative delays, got %v", d)
+	}
+	check(c.Retry.Kind != RetrySchedule || len(c.Retry.Schedule) > 0, "retry.schedule is required for the %q kind", RetrySchedule)
+	check(c.MaxLease >= 0, "max_lease can't be negative, got %v", c.MaxLease)
+	check(c.SegmentMaxBytes >= 0, "segment_max_bytes can't be negative, got %d", c.SegmentMaxBytes)
+	check(c.SegmentMaxAge >= 0, "segment_max_age can't be negative, got %v", c.SegmentMaxAge)
+	check(c.MessageTTL >= 0, "message_ttl can't be negative, got %v", c.MessageTTL)
+	check(c.DedupWindow >= 0, "dedup_window can't be negative, got %v", c.DedupWindow)
+	check(c.DedupMaxKeys >= 0, "dedup_max_keys can't be negative, got %d", c.DedupMaxKeys)
+	check(c.PriorityLevels >= 0 && c.PriorityLevels <= MaxPriorityLevels,
+		"priority_levels must be between 0 and %d, got %d", MaxPriorityLevels, c.PriorityLevels)
+	check(len(c.PriorityWeights) == 0 || len(c.PriorityWeights) == c.PriorityLevels,
+		"priority_weights needs one weight per level (%d), got %d", c.PriorityLevels, len(c.PriorityWeights))
+	for _, w := range c.PriorityWeights {
+		check(w > 0, "priority_weights must be positive, got %d", w)
+	}
+	check(slices.Contains([]Durability{DurabilityNone, DurabilityInterval, DurabilityGroupCommit}, c.Durability),
+		"durability must be %q, %q or empty, got %q", DurabilityInterval, DurabilityGroupCommit, c.Durability)
+	check(c.SyncInterval >= 0, "sync_interval can't be negative, got %v", c.SyncInterval)
+	check(c.WALChannelDepth >= 0 && c.WALChannelDepth <= MaxWALChannelDepth,
+		"wal_channel_depth must be between 0 and %d, got %d", MaxWALChannelDepth, c.WALChannelDepth)
+	check(c.WALBatchSize >= 0, "wal_batch_size can't be negative, got %d", c.WALBatchSize)
+	check(c.WALFlushInterval >= 0, "wal_flush_interval can't be negative, got %v", c.WALFlushInterval)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(errs...))
+}
+
+// Reports the first setting that differs between old and new but can't
+// change while the topic is running, "" if there is none
+func fixedSettingChanged(old, new TopicConfig) string {
+	switch {
+	case old.Capacity != new.Capacity:
+		return "capacity"
+	case old.SegmentMaxBytes != new.SegmentMaxBytes:
+		return "segment_max_bytes"
+	case old.SegmentMaxAge != new.SegmentMaxAge:
+		return "segment_max_age"
+	case old.CheckpointInterval != new.CheckpointInterval:
+		return "checkpoint_interval"
+	case old.DeadLetterTopic != new.DeadLetterTopic:
+		return "dead_letter_topic"
+	case old.PriorityLevels != new.PriorityLevels:
+		return "priority_levels"
+	case !slices.Equal(old.PriorityWeights, new.PriorityWeights):
+		return "priority_weights"
+	case old.Durability != new.Durability:
+		return "durability"
+	case old.SyncInterval != new.SyncInterval:
+		return "sync_interval"
+	case old.WALChannelDepth != new.WALChannelDepth:
+		return "wal_channel_depth"
+	case old.WALBatchSize != new.WALBatchSize:
+		return "wal_batch_size"
+	case old.WALFlushInterval != new.WALFlushInterval:
+		return "wal_flush_interval"
+	}
+	return ""
+}
+
+// Config returns the topic's current config
+func (t *Topic) Config() TopicConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.config
+}
+
+// UpdateConfig applies config to the running topic and persists it.
+// Only timeouts, retries, leases, TTL and deduplication can change,
+// other settings must stay as they are (ErrConfigFixed).
+func (t *Topic) UpdateConfig(config TopicConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return ErrTopicClosed
+	}
+	if field := fixedSettingChanged(t.config, config); field != "" {
+		return fmt.Errorf("%w: %s", ErrConfigFixed, field)
+	}
+
+	// The other settings are equal already
+	t.config.AckTimeout = config.AckTimeout
+	t.config.MaxRetries = config.MaxRetries
+	t.config.Retry = config.Retry
+	t.config.MaxLease = config.MaxLease
+	t.config.MessageTTL = config.MessageTTL
+	t.config.DeadLetterExpired = config.DeadLetterExpired
+	t.config.DedupWindow = config.DedupWindow
+	t.config.DedupMaxKeys = config.DedupMaxKeys
+
+	t.dedup.resize(config)
+	// Deadlines in the sweep were computed with the old AckTimeout
+	t.watchAllLeases()
+
+	return saveConfig(t.wal.dir, config)
+}
+
+// saveConfig atomically replaces the config kept in dir
+func saveConfig(dir string, config TopicConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := metaPath(dir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// loadConfig reads the config kept in dir. Settings missing from the
+// file keep their value in defaults.
+func loadConfig(dir string, defaults TopicConfig) (TopicConfig, error) {
+	data, err := os.ReadFile(metaPath(dir))
+	if err != nil {
+		return defaults, err
+	}
+
+	config := defaults
+	if err := json.Unmarshal(data, &config); err != nil {
+		return defaults, err
+	}
+	return config, nil
+}
@@ -0,0 +1,215 @@
+package queue
+
+import (
+	"container/heap"
+	"log"
+	"strings"
+	"time"
+)
+
+const deadLetterSuffix = ".dlq"
+
+// Name of the topic receiving name's dead letters, "" if it has none.
+// Dead-letter topics don't dead-letter again, their messages are dropped.
+func deadLetterTopicName(name string, config TopicConfig) string {
+	dlq := config.DeadLetterTopic
+	if dlq == "" {
+		if strings.HasSuffix(name, deadLetterSuffix) {
+			return ""
+		}
+		dlq = name + deadLetterSuffix
+	}
+
+	if dlq == name {
+		return ""
+	}
+	return dlq
+}
+
+// SetDeadLetterSink sets where messages exceeding MaxRetries are sent.
+// With no sink they are dropped.
+func (t *Topic) SetDeadLetterSink(sink func(Message) error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.deadLetterSink = sink
+}
+
+// deadLetter hands group's settled msgs to the sink first and only then
+// records them as dead, so a crash in between redelivers them instead of
+// losing them. Messages the sink refuses go back in flight with a fresh
+// lease, the retry sweep tries again once it runs out.
+// msgs still own their message group. Callers don't hold t.mu
+func (t *Topic) deadLetter(group string, msgs []Message, reason string) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	failed := make(map[int64]bool)
+	for _, msg := range t.sendToDeadLetter(group, msgs, reason) {
+		failed[msg.ID] = true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.groups[group] // nil if unsubscribed meanwhile
+	now := time.Now()
+	for _, msg := range msgs {
+		if failed[msg.ID] {
+			if g != nil {
+				msg.Timestamp = now
+				msg.Receipt = newReceipt(msg.ID)
+				g.inFlight[msg.ID] = msg
+				t.watchLease(g, msg)
+			}
+			continue
+		}
+
+		t.wal.Append(LogEntry{Type: "dead", Group: group, Message: msg})
+		if g != nil {
+			g.release(msg)
+		}
+	}
+}
+
+// Hands msgs to the dead-letter sink, dropping them if there is none.
+// Returns the messages the sink refused.
+func (t *Topic) sendToDeadLetter(group string, msgs []Message, reason string) (failed []Message) {
+	t.mu.Lock()
+	sink := t.deadLetterSink
+	t.mu.Unlock()
+
+	for _, msg := range msgs {
+		if sink == nil {
+			log.Printf("[DROP]: Msg ID %d %s. Discarded.\n", msg.ID, reason)
+			droppedTotal.Inc(t.Name, "no_dead_letter_topic")
+			continue
+		}
+
+		dl := Message{
+			Payload:      msg.Payload,
+			Headers:      msg.Headers,
+			Priority:     msg.Priority,
+			MessageGroup: msg.MessageGroup,
+			DedupKey:     msg.DedupKey,
+			DeadLetter: &DeadLetter{
+				Topic:   t.Name,
+				Group:   group,
+				ID:      msg.ID,
+				Retries: msg.Retries,
+				Reason:  reason,
+			},
+		}
+		if err := sink(dl); err != nil {
+			log.Printf("[DLQ ERROR] Topic: %s | Group: %s | Msg ID %d could not be dead-lettered: %v. Kept for another attempt.\n", t.Name, group, msg.ID, err)
+			failed = append(failed, msg)
+			continue
+		}
+		deadLetteredTotal.Inc(t.Name)
+		log.Printf("[DLQ] Topic: %s | Group: %s | Msg ID %d dead-lettered (%s)\n", t.Name, group, msg.ID, reason)
+	}
+
+	return failed
+}
+
+// Pending returns up to limit messages waiting for delivery in any
+// consumer group, by ID, without delivering them (limit <= 0 = all)
+func (t *Topic) Pending(limit int) []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending := make(map[int64]Message)
+	for _, g := range t.groups {
+		for _, msg := range g.messages.Items() {
+			pending[msg.ID] = msg
+		}
+		for _, d := range g.delayed {
+			pending[d.msg.ID] = d.msg
+		}
+		for _, msg := range g.heldMessages() {
+			pending[msg.ID] = msg
+		}
+	}
+
+	items := make([]Message, 0, len(pending))
+	for _, id := range sortedIDs(pending) {
+		if limit > 0 && len(items) == limit {
+			break
+		}
+		items = append(items, pending[id])
+	}
+
+	return items
+}
+
+// Remove drops the pending messages with the given IDs from every
+// consumer group. Returns how many were removed.
+func (t *Topic) Remove(ids []int64) int {
+	set := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return t.removePending(func(msg Message) bool { return set[msg.ID] })
+}
+
+// Purge drops every pending message of every consumer group.
+// In-flight messages are kept. Returns how many were removed.
+func (t *Topic) Purge() int {
+	removed := t.removePending(func(Message) bool { return true })
+	droppedTotal.Add(float64(removed), t.Name, "purged")
+	return removed
+}
+
+func (t *Topic) removePending(match func(Message) bool) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	removed := 0
+	for _, g := range t.groups {
+		// Held messages go first, so purging their group's owner below
+		// can't release one that is being purged
+		for _, msg := range g.removeHeld(match) {
+			t.wal.Append(LogEntry{Type: "purge", Group: g.name, Message: msg})
+			removed++
+		}
+
+		kept := make([]Message, 0, g.messages.Size())
+		for {
+			msg, ok := g.messages.Dequeue()
+			if !ok {
+				break
+			}
+
+			if !match(msg) {
+				kept = append(kept, msg)
+				continue
+			}
+
+			t.wal.Append(LogEntry{Type: "purge", Group: g.name, Message: msg})
+			g.release(msg)
+			removed++
+		}
+
+		for _, msg := range kept {
+			g.messages.Enqueue(msg)
+		}
+
+		delayed := g.delayed[:0]
+		for _, d := range g.delayed {
+			if !match(d.msg) {
+				delayed = append(delayed, d)
+				continue
+			}
+
+			t.wal.Append(LogEntry{Type: "purge", Group: g.name, Message: d.msg})
+			g.release(d.msg)
+			removed++
+		}
+		g.delayed = delayed
+		heap.Init(&g.delayed)
+	}
+
+	return removed
+}
@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"slices"
+	"time"
+)
+
+// Defaults for how long producer deduplication keys are remembered
+const (
+	DefaultDedupWindow  = 5 * time.Minute
+	DefaultDedupMaxKeys = 100000
+)
+
+// dedupIndex remembers the message ID each deduplication key was first
+// published under, for a window of time and up to a number of keys.
+// Keys are evicted oldest first.
+type dedupIndex struct {
+	window  time.Duration
+	maxKeys int
+
+	ids   map[string]int64
+	order []dedupKey // oldest first
+
+	// Commits of group-commit publishes not known to be synced yet,
+	// a duplicate can't be answered before they are
+	commits map[string]*Commit
+}
+
+type dedupKey struct {
+	key string
+	id  int64
+	at  time.Time
+}
+
+func newDedupIndex(config TopicConfig) *dedupIndex {
+	d := &dedupIndex{
+		window:  config.DedupWindow,
+		maxKeys: config.DedupMaxKeys,
+		ids:     make(map[string]int64),
+		commits: make(map[string]*Commit),
+	}
+	if d.window <= 0 {
+		d.window = DefaultDedupWindow
+	}
+	if d.maxKeys <= 0 {
+		d.maxKeys = DefaultDedupMaxKeys
+	}
+	return d
+}
+
+// resize applies config's window and key limit to a running index
+func (d *dedupIndex) resize(config TopicConfig) {
+	resized := newDedupIndex(config)
+	d.window, d.maxKeys = resized.window, resized.maxKeys
+
+	for len(d.order) > d.maxKeys {
+		d.removeOldest()
+	}
+}
+
+// ID the key was published under, false if it's not remembered. The
+// Commit is that of the publish if it's still being synced, nil once it
+// is. A key whose publish failed to sync is forgotten.
+func (d *dedupIndex) lookup(key string, now time.Time) (int64, *Commit, bool) {
+	d.evict(now)
+	id, ok := d.ids[key]
+	if !ok {
+		return 0, nil, false
+	}
+
+	commit := d.commits[key]
+	if commit == nil {
+		return id, nil, true
+	}
+	done, err := commit.result()
+	if !done {
+		return id, commit, true
+	}
+	if err != nil {
+		d.forget(key, id)
+		return 0, nil, false
+	}
+	delete(d.commits, key)
+	return id, nil, true
+}
+
+// add remembers key for the message id published at. A key already
+// remembered keeps its first ID.
+func (d *dedupIndex) add(key string, id int64, at time.Time) {
+	if _, ok := d.ids[key]; ok {
+		return
+	}
+
+	d.ids[key] = id
+	d.order = append(d.order, dedupKey{key: key, id: id, at: at})
+	for len(d.order) > d.maxKeys {
+		d.removeOldest()
+	}
+}
+
+// committing records the commit syncing the publish of key under id
+func (d *dedupIndex) committing(key string, id int64, commit *Commit) {
+	if commit != nil && key != "" && d.ids[key] == id {
+		d.commits[key] = commit
+	}
+}
+
+// synced drops the commit of key once it succeeded
+func (d *dedupIndex) synced(key string, commit *Commit) {
+	if d.commits[key] == commit {
+		delete(d.commits, key)
+	}
+}
+
+// forget drops key if it's still remembered for id
+func (d *dedupIndex) forget(key string, id int64) {
+	if key == "" || d.ids[key] != id {
+		return
+	}
+
+	delete(d.ids, key)
+	delete(d.commits, key)
+	d.order = slices.DeleteFunc(d.order, func(k dedupKey) bool { return k.key == key })
+}
+
+// Drops keys that fell out of the window
+func (d *dedupIndex) evict(now time.Time) {
+	for len(d.order) > 0 && now.Sub(d.order[0].at) > d.window {
+		d.removeOldest()
+	}
+}
+
+func (d *dedupIndex) removeOldest() {
+	delete(d.ids, d.order[0].key)
+	delete(d.commits, d.order[0].key)
+	d.order = d.order[1:]
+}
@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"container/heap"
+	"time"
+)
+
+// delayedMsg is a message held back from its group until due
+type delayedMsg struct {
+	msg Message
+	due time.Time
+}
+
+// delayQueue is a min-heap of delayed messages, earliest due first.
+// Messages due at the same time come out in ID order.
+type delayQueue []delayedMsg
+
+func (d delayQueue) Len() int { return len(d) }
+
+func (d delayQueue) Less(i, j int) bool {
+	if !d[i].due.Equal(d[j].due) {
+		return d[i].due.Before(d[j].due)
+	}
+	return d[i].msg.ID < d[j].msg.ID
+}
+
+func (d delayQueue) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+
+func (d *delayQueue) Push(x any) { *d = append(*d, x.(delayedMsg)) }
+
+func (d *delayQueue) Pop() any {
+	old := *d
+	item := old[len(old)-1]
+	*d = old[:len(old)-1]
+	return item
+}
+
+// delay holds msg back until due and tells the scheduler.
+// Callers hold t.mu
+func (t *Topic) delay(g *consumerGroup, msg Message, due time.Time) {
+	heap.Push(&g.delayed, delayedMsg{msg: msg, due: due})
+	t.reschedule()
+}
+
+// reschedule wakes the scheduler to look at a new deadline
+func (t *Topic) reschedule() {
+	select {
+	case t.rescheduleCh <- struct{}{}:
+	default: // a wakeup is already pending
+	}
+}
+
+// releaseDue moves every delayed message that is due into its group's
+// queue and returns when the next one is due (zero if none is left)
+func (t *Topic) releaseDue(now time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var next time.Time
+	for _, name := range t.groupNames() {
+		g := t.groups[name]
+		for g.delayed.Len() > 0 && !g.delayed[0].due.After(now) {
+			g.push(heap.Pop(&g.delayed).(delayedMsg).msg)
+		}
+		if g.delayed.Len() > 0 && (next.IsZero() || g.delayed[0].due.Before(next)) {
+			next = g.delayed[0].due
+		}
+	}
+
+	return next
+}
+
+// runScheduler releases delayed messages as they become due and
+// retries deliveries as their ack times out
+func (t *Topic) runScheduler() {
+	defer t.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		now := time.Now()
+		t.retryExpired(now)
+		next := t.releaseDue(now)
+
+		t.mu.Lock()
+		if lease := t.nextLeaseDeadline(); !lease.IsZero() && (next.IsZero() || lease.Before(next)) {
+			next = lease
+		}
+		t.mu.Unlock()
+
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-t.rescheduleCh:
+		case <-t.closeCh:
+			return
+		}
+	}
+}
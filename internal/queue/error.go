@@ -3,5 +3,18 @@ package queue
 import "errors"
 
 var (
-	ErrEmptyQueue = errors.New("queue empty")
+	ErrEmptyQueue    = errors.New("queue empty")
+	ErrTopicNotFound = errors.New("topic not found")
+	ErrGroupNotFound = errors.New("consumer group not found")
+	ErrLeaseLimit    = errors.New("maximum lease reached")
+	ErrTopicClosed   = errors.New("topic closed")
+
+	ErrInvalidTopicName = errors.New("invalid topic name")
+	ErrInvalidConfig    = errors.New("invalid topic config")
+	// The setting is fixed once the topic exists
+	ErrConfigFixed = errors.New("setting can't be changed on an existing topic")
+
+	ErrInvalidReceipt = errors.New("invalid receipt")
+	// The delivery was already settled or redelivered under a new receipt
+	ErrStaleReceipt = errors.New("stale receipt")
 )
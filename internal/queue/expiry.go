@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"log"
+	"slices"
+	"time"
+)
+
+// Whether msg's TTL ran out by now
+func expired(msg Message, now time.Time) bool {
+	return !msg.ExpiresAt.IsZero() && !msg.ExpiresAt.After(now)
+}
+
+// expire records group's msgs as expired. They are dead-lettered if the
+// topic is configured to, dropped otherwise. Messages the dead-letter
+// sink refuses stay pending for another attempt after the ack timeout.
+// Callers don't hold t.mu
+func (t *Topic) expire(group string, msgs []Message) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	deadLetterExpired := t.config.DeadLetterExpired
+	t.mu.Unlock()
+
+	var failed []Message
+	if deadLetterExpired {
+		failed = t.sendToDeadLetter(group, msgs, "expired")
+	} else {
+		for _, msg := range msgs {
+			log.Printf("[Expire] Topic: %s | Group: %s | Msg ID %d expired. Discarded.\n", t.Name, group, msg.ID)
+		}
+		droppedTotal.Add(float64(len(msgs)), t.Name, "expired")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Still pending on disk, so only held back in memory
+	if g := t.groups[group]; g != nil {
+		for _, msg := range failed {
+			t.delay(g, msg, time.Now().Add(t.config.AckTimeout))
+		}
+	}
+
+	for _, msg := range msgs {
+		if !slices.ContainsFunc(failed, func(f Message) bool { return f.ID == msg.ID }) {
+			t.wal.Append(LogEntry{Type: "expire", Group: group, Message: msg})
+			t.expired++
+		}
+	}
+}
+
+// TopicStats is a point-in-time summary of a topic
+type TopicStats struct {
+	Groups   int   `json:"groups"`
+	Pending  int   `json:"pending"`   // waiting for delivery, summed over groups
+	Delayed  int   `json:"delayed"`   // scheduled or nacked with a delay
+	InFlight int   `json:"in_flight"` // delivered but not yet acked
+	Expired  int64 `json:"expired"`   // expired since the topic was loaded
+}
+
+// Stats returns the topic's current counts
+func (t *Topic) Stats() TopicStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := TopicStats{Groups: len(t.groups), Expired: t.expired}
+	for _, g := range t.groups {
+		stats.Pending += int(g.messages.Size()) + len(g.heldMessages())
+		stats.Delayed += g.delayed.Len()
+		stats.InFlight += len(g.inFlight)
+	}
+
+	return stats
+}
@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+)
+
+// Messages sharing a MessageGroup are delivered one at a time and in
+// order within each consumer group: while one of them is in flight (or
+// waiting out a nack delay) it owns the message group, and later ones
+// dequeued meanwhile are held back. Different message groups are
+// consumed in parallel.
+
+// take reports whether msg may be delivered now, holding it back
+// otherwise. Callers hold the topic lock
+func (g *consumerGroup) take(msg Message) bool {
+	key := msg.MessageGroup
+	if key == "" {
+		return true
+	}
+
+	if owner, ok := g.owners[key]; ok && owner != msg.ID {
+		g.held[key] = append(g.held[key], msg)
+		return false
+	}
+
+	g.owners[key] = msg.ID
+	return true
+}
+
+// release is called once msg is done with for good (acked, dead,
+// expired or purged). If it owned its message group, ownership passes
+// to the next held message, which is queued again.
+// Callers hold the topic lock
+func (g *consumerGroup) release(msg Message) {
+	key := msg.MessageGroup
+	if key == "" || g.owners[key] != msg.ID {
+		return
+	}
+
+	held := g.held[key]
+	if len(held) == 0 {
+		delete(g.owners, key)
+		return
+	}
+
+	next := held[0]
+	if len(held) == 1 {
+		delete(g.held, key)
+	} else {
+		g.held[key] = held[1:]
+	}
+
+	g.owners[key] = next.ID
+	g.push(next)
+}
+
+// Messages held back behind their message group's owner
+func (g *consumerGroup) heldMessages() []Message {
+	var msgs []Message
+	for _, key := range slices.Sorted(maps.Keys(g.held)) {
+		msgs = append(msgs, g.held[key]...)
+	}
+	return msgs
+}
+
+// removeHeld drops the held messages matching match, returning them
+func (g *consumerGroup) removeHeld(match func(Message) bool) []Message {
+	var removed []Message
+	for key, held := range g.held {
+		kept := held[:0]
+		for _, msg := range held {
+			if match(msg) {
+				removed = append(removed, msg)
+				continue
+			}
+			kept = append(kept, msg)
+		}
+
+		if len(kept) == 0 {
+			delete(g.held, key)
+		} else {
+			g.held[key] = kept
+		}
+	}
+	return removed
+}
+
+// orderMessageGroups puts the messages of each message group back in ID
+// (publish) order, keeping the slots the group occupies in msgs. A nacked
+// message is logged after later messages of its group were published,
+// so replay alone would put it behind them.
+func orderMessageGroups(msgs []Message) {
+	slots := make(map[string][]int)
+	for i, msg := range msgs {
+		if msg.MessageGroup != "" {
+			slots[msg.MessageGroup] = append(slots[msg.MessageGroup], i)
+		}
+	}
+
+	for _, idx := range slots {
+		group := make([]Message, len(idx))
+		for i, at := range idx {
+			group[i] = msgs[at]
+		}
+		slices.SortFunc(group, func(a, b Message) int { return cmp.Compare(a.ID, b.ID) })
+		for i, at := range idx {
+			msgs[at] = group[i]
+		}
+	}
+}
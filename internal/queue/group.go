@@ -0,0 +1,206 @@
+package queue
+
+import (
+	"log"
+	"slices"
+	"time"
+
+	"github.com/suman7383/go-queue/internal/priority"
+	"github.com/suman7383/go-queue/internal/ringbuffer"
+)
+
+// DefaultGroup is used when a consumer doesn't name a group. It exists
+// from the moment a topic is created, so a topic consumed without groups
+// behaves like a plain competing-consumer queue.
+const DefaultGroup = "default"
+
+// DefaultCapacity is the initial size of a consumer group's queue
+const DefaultCapacity = 10000
+
+// Queues are allocated upfront, these bound what one config can ask for
+const (
+	MaxCapacity       = 1 << 20
+	MaxPriorityLevels = 16 // default weights double per level
+)
+
+// consumerGroup is one subscriber of a topic. It receives every message
+// published from its cursor on and tracks deliveries, acks and retries
+// independently of the other groups.
+type consumerGroup struct {
+	name     string
+	cursor   int64 // first message ID the group receives
+	messages Queue[Message]
+	inFlight map[int64]Message   // delivered but not yet acked
+	leases   map[int64]time.Time // extended deadlines of in-flight messages
+	delayed  delayQueue          // nacked with a delay, not deliverable yet
+
+	// FIFO message groups, see take
+	owners map[string]int64     // message group -> ID of the message it waits on
+	held   map[string][]Message // messages waiting for their group, in order
+
+	// Consumers blocked in DequeueWait, oldest first
+	waiters []chan struct{}
+}
+
+func newConsumerGroup(name string, cursor int64, config TopicConfig) *consumerGroup {
+	return &consumerGroup{
+		name:     name,
+		cursor:   cursor,
+		messages: newMessageQueue(config),
+		inFlight: make(map[int64]Message),
+		leases:   make(map[int64]time.Time),
+		owners:   make(map[string]int64),
+		held:     make(map[string][]Message),
+	}
+}
+
+// Plain FIFO, or one lane per priority level if the topic has them
+func newMessageQueue(config TopicConfig) Queue[Message] {
+	capacity := config.Capacity
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+
+	if config.PriorityLevels <= 1 {
+		return ringbuffer.NewRingBuffer[Message](capacity)
+	}
+
+	weights := priority.DefaultWeights(config.PriorityLevels)
+	if len(config.PriorityWeights) == config.PriorityLevels {
+		for i, w := range config.PriorityWeights {
+			weights[i] = max(w, 1)
+		}
+	}
+
+	return priority.NewLanes(weights, max(capacity/int64(config.PriorityLevels), 1), func(msg Message) int {
+		return msg.Priority
+	})
+}
+
+// When the in-flight msg is redelivered unless acked
+func (g *consumerGroup) deadline(msg Message, ackTimeout time.Duration) time.Time {
+	if lease, ok := g.leases[msg.ID]; ok {
+		return lease
+	}
+	return msg.Timestamp.Add(ackTimeout)
+}
+
+// settle drops id from the in-flight messages
+func (g *consumerGroup) settle(id int64) {
+	delete(g.inFlight, id)
+	delete(g.leases, id)
+}
+
+// push queues msg and wakes one waiting consumer for it.
+// Callers hold the topic lock
+func (g *consumerGroup) push(msg Message) {
+	g.messages.Enqueue(msg)
+	g.wake(1)
+}
+
+// wake signals up to n waiting consumers, oldest first. Waking one
+// consumer per message avoids stampeding every waiter for each message.
+func (g *consumerGroup) wake(n int) {
+	for ; n > 0 && len(g.waiters) > 0; n-- {
+		g.waiters[0] <- struct{}{} // buffered, never blocks
+		g.waiters = g.waiters[1:]
+	}
+}
+
+// Removes ch from the waiters, false if it was already woken
+func (g *consumerGroup) cancelWait(ch chan struct{}) bool {
+	for i, w := range g.waiters {
+		if w == ch {
+			g.waiters = slices.Delete(g.waiters, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+// Maps the empty group name to DefaultGroup
+func groupName(name string) string {
+	if name == "" {
+		return DefaultGroup
+	}
+	return name
+}
+
+// Subscribe adds a consumer group to the topic. The group receives every
+// message published from now on. Subscribing an existing group is a no-op.
+func (t *Topic) Subscribe(group string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.subscribe(groupName(group))
+}
+
+// consumeGroup returns the group consumers of name read from. Only
+// DefaultGroup is subscribed on demand. Callers hold t.mu
+func (t *Topic) consumeGroup(name string) (*consumerGroup, error) {
+	name = groupName(name)
+	if g := t.groups[name]; g != nil {
+		return g, nil
+	}
+	if name != DefaultGroup {
+		return nil, ErrGroupNotFound
+	}
+	return t.subscribe(name), nil
+}
+
+// subscribe returns the named group, creating it if needed.
+// Callers hold t.mu
+func (t *Topic) subscribe(name string) *consumerGroup {
+	if g, ok := t.groups[name]; ok {
+		return g
+	}
+
+	g := newConsumerGroup(name, t.nextID, t.config)
+	t.groups[name] = g
+
+	// The cursor is persisted so the group survives restarts
+	t.wal.Append(LogEntry{Type: "subscribe", Group: name, Message: Message{ID: g.cursor}})
+	log.Printf("[Group] Topic: %s | group '%s' subscribed from ID %d\n", t.Name, name, g.cursor)
+
+	return g
+}
+
+// Unsubscribe removes a consumer group along with everything it still
+// had pending or in flight. Returns false if there was no such group.
+func (t *Topic) Unsubscribe(group string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	name := groupName(group)
+	g, ok := t.groups[name]
+	if !ok {
+		return false
+	}
+
+	// Waiting consumers find the group gone and give up
+	g.wake(len(g.waiters))
+
+	delete(t.groups, name)
+	t.wal.Append(LogEntry{Type: "unsubscribe", Group: name})
+	log.Printf("[Group] Topic: %s | group '%s' unsubscribed\n", t.Name, name)
+
+	return true
+}
+
+// Groups returns the names of the topic's consumer groups in order
+func (t *Topic) Groups() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.groupNames()
+}
+
+// Callers hold t.mu
+func (t *Topic) groupNames() []string {
+	names := make([]string, 0, len(t.groups))
+	for name := range t.groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,30 @@
+package queue
+
+import "fmt"
+
+// Health reports why the topic can't be served reliably, nil if it can
+func (t *Topic) Health() error {
+	if t.replayErr != nil {
+		return fmt.Errorf("WAL replay failed: %w", t.replayErr)
+	}
+	return t.wal.Health()
+}
+
+// Recovered reports whether topics were loaded from disk
+func (r *TopicRegistry) Recovered() bool {
+	return r.recovered.Load()
+}
+
+// Health returns the problem of every unhealthy topic, by name
+func (r *TopicRegistry) Health() map[string]error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	problems := make(map[string]error)
+	for name, topic := range r.topics {
+		if err := topic.Health(); err != nil {
+			problems[name] = err
+		}
+	}
+	return problems
+}
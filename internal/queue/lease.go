@@ -0,0 +1,151 @@
+package queue
+
+import (
+	"container/heap"
+	"log"
+	"time"
+)
+
+// DefaultMaxLease bounds how long a consumer can keep extending a
+// delivery when TopicConfig.MaxLease is unset
+const DefaultMaxLease = 12 * time.Hour
+
+func maxLease(config TopicConfig) time.Duration {
+	if config.MaxLease <= 0 {
+		return DefaultMaxLease
+	}
+	return config.MaxLease
+}
+
+// ExtendLease pushes the redelivery deadline of the delivery receipt
+// was issued for to by from now (or from its current deadline if that
+// is later). A delivery can't be held past MaxLease: the deadline is
+// capped there, and ErrLeaseLimit is returned once it can't move any
+// further. Returns the new deadline.
+func (t *Topic) ExtendLease(group, receipt string, by time.Duration) (time.Time, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g, msg, err := t.inFlight(group, receipt)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	current := g.deadline(msg, t.config.AckTimeout)
+	deadline := time.Now()
+	if current.After(deadline) {
+		deadline = current
+	}
+	deadline = deadline.Add(by)
+
+	if limit := msg.Timestamp.Add(maxLease(t.config)); deadline.After(limit) {
+		deadline = limit
+	}
+	if !deadline.After(current) {
+		return current, ErrLeaseLimit
+	}
+
+	// Append to WAL
+	t.wal.Append(LogEntry{Type: "extend", Group: g.name, Message: msg, DueAt: deadline})
+	g.leases[msg.ID] = deadline
+	t.watchLease(g, msg)
+
+	log.Printf("[Lease] Topic: %s | Group: %s | Msg ID %d | deadline %s\n", t.Name, g.name, msg.ID, deadline.Format(time.RFC3339))
+	return deadline, nil
+}
+
+// leaseDeadline is when one delivery times out unless acked
+type leaseDeadline struct {
+	at      time.Time
+	group   string
+	id      int64
+	receipt string // the delivery it belongs to
+}
+
+// leaseQueue is a min-heap of delivery deadlines, earliest first. Entries
+// aren't removed when a delivery is settled or extended, the sweep skips
+// the ones that no longer match.
+type leaseQueue []leaseDeadline
+
+func (l leaseQueue) Len() int { return len(l) }
+
+func (l leaseQueue) Less(i, j int) bool {
+	if !l[i].at.Equal(l[j].at) {
+		return l[i].at.Before(l[j].at)
+	}
+	return l[i].id < l[j].id
+}
+
+func (l leaseQueue) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+func (l *leaseQueue) Push(x any) { *l = append(*l, x.(leaseDeadline)) }
+
+func (l *leaseQueue) Pop() any {
+	old := *l
+	item := old[len(old)-1]
+	*l = old[:len(old)-1]
+	return item
+}
+
+// watchLease schedules the in-flight msg's deadline for the retry sweep.
+// Callers hold t.mu
+func (t *Topic) watchLease(g *consumerGroup, msg Message) {
+	at := g.deadline(msg, t.config.AckTimeout)
+	heap.Push(&t.leaseDeadlines, leaseDeadline{at: at, group: g.name, id: msg.ID, receipt: msg.Receipt})
+
+	// The scheduler only needs waking for a new earliest deadline
+	if t.leaseDeadlines[0].at.Equal(at) {
+		t.reschedule()
+	}
+}
+
+// expiredLeases pops the deliveries that timed out by now, by deadline.
+// Callers hold t.mu
+func (t *Topic) expiredLeases(now time.Time) map[*consumerGroup][]Message {
+	expired := make(map[*consumerGroup][]Message)
+	for t.leaseDeadlines.Len() > 0 && t.leaseDeadlines[0].at.Before(now) {
+		d := heap.Pop(&t.leaseDeadlines).(leaseDeadline)
+
+		g := t.groups[d.group]
+		if g == nil {
+			continue // unsubscribed
+		}
+		msg, ok := g.inFlight[d.id]
+		if !ok || msg.Receipt != d.receipt || msg.Acked {
+			continue // settled or redelivered since
+		}
+		if deadline := g.deadline(msg, t.config.AckTimeout); deadline.After(now) {
+			if !deadline.Equal(d.at) {
+				t.watchLease(g, msg) // moved without a new entry, eg: AckTimeout changed
+			}
+			continue // extended
+		}
+
+		expired[g] = append(expired[g], msg)
+	}
+	return expired
+}
+
+// watchAllLeases rebuilds the deadline heap from every in-flight
+// message, eg: after recovery or a change of AckTimeout.
+// Callers hold t.mu
+func (t *Topic) watchAllLeases() {
+	t.leaseDeadlines = t.leaseDeadlines[:0]
+	for _, name := range t.groupNames() {
+		g := t.groups[name]
+		for _, id := range sortedIDs(g.inFlight) {
+			msg := g.inFlight[id]
+			t.leaseDeadlines = append(t.leaseDeadlines, leaseDeadline{at: g.deadline(msg, t.config.AckTimeout), group: name, id: id, receipt: msg.Receipt})
+		}
+	}
+	heap.Init(&t.leaseDeadlines)
+	t.reschedule()
+}
+
+// Earliest deadline the sweep has to look at, zero if none
+func (t *Topic) nextLeaseDeadline() time.Time {
+	if t.leaseDeadlines.Len() == 0 {
+		return time.Time{}
+	}
+	return t.leaseDeadlines[0].at
+}
@@ -0,0 +1,163 @@
+package queue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Before segments, each topic had a single unframed log
+// eg: data/orders.wal
+const legacyWALExt = ".wal"
+
+// migrateLegacyWALs imports every data/<topic>.wal into the first segment
+// of its topic. The old file is kept, renamed to <topic>.wal.migrated.
+func migrateLegacyWALs() {
+	files, err := os.ReadDir(DataDir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), legacyWALExt) {
+			continue
+		}
+
+		topicName := strings.TrimSuffix(file.Name(), legacyWALExt)
+		if err := validateTopicName(topicName); err != nil {
+			continue
+		}
+
+		path := filepath.Join(DataDir, file.Name())
+		n, err := migrateLegacyWAL(path, topicDir(topicName))
+		if err != nil {
+			log.Printf("[Recovery ERROR] Topic '%s': migrating %s failed: %v\n", topicName, path, err)
+			continue
+		}
+		log.Printf("[Recovery] Topic '%s': migrated %d entries from %s\n", topicName, n, path)
+	}
+}
+
+// migrateLegacyWAL rewrites the entries of the legacy log at path as
+// segment 1 of dir. Returns how many entries were imported.
+func migrateLegacyWAL(path, dir string) (int, error) {
+	// Segments only exist next to a legacy log if a previous migration
+	// got as far as renaming the segment into place
+	if ids, _ := listSegments(dir); len(ids) > 0 {
+		return 0, os.Rename(path, path+".migrated")
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	// Written aside and renamed into place once complete, so a crash
+	// mid-migration just migrates again on the next start
+	seg := segmentPath(dir, 1)
+	tmp := seg + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := func() (int, error) {
+		reader := bufio.NewReader(src)
+		writer := bufio.NewWriter(dst)
+
+		n := 0
+		for {
+			entry, err := readLegacyEntry(reader)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				// The legacy replay stopped at the first bad entry too
+				log.Printf("[Recovery] %s: stopped at entry %d: %v\n", path, n+1, err)
+				break
+			}
+			if err := writeRecord(writer, entry); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if err := writer.Flush(); err != nil {
+			return n, err
+		}
+		return n, dst.Sync()
+	}()
+
+	dst.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return n, err
+	}
+	if err := os.Rename(tmp, seg); err != nil {
+		return n, err
+	}
+
+	return n, os.Rename(path, path+".migrated")
+}
+
+// readLegacyEntry decodes one entry of a legacy log: type (uint16 length
+// + string), ID, payload (uint32 length + bytes), timestamp, acked and
+// retries. io.EOF means a clean end between entries.
+func readLegacyEntry(reader io.Reader) (LogEntry, error) {
+	var typeLen uint16
+	if err := binary.Read(reader, binary.LittleEndian, &typeLen); err != nil {
+		return LogEntry{}, err
+	}
+
+	typeBytes := make([]byte, typeLen)
+	if _, err := io.ReadFull(reader, typeBytes); err != nil {
+		return LogEntry{}, io.ErrUnexpectedEOF
+	}
+
+	var header struct {
+		ID         int64
+		PayloadLen uint32
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return LogEntry{}, io.ErrUnexpectedEOF
+	}
+	if header.PayloadLen > maxRecordSize {
+		return LogEntry{}, errRecordLength
+	}
+
+	payload := make([]byte, header.PayloadLen)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return LogEntry{}, io.ErrUnexpectedEOF
+	}
+
+	var trailer struct {
+		Timestamp int64
+		Acked     uint8
+		Retries   int32
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &trailer); err != nil {
+		return LogEntry{}, io.ErrUnexpectedEOF
+	}
+
+	return LogEntry{
+		Type: string(typeBytes),
+		Message: Message{
+			ID:        header.ID,
+			Payload:   payload,
+			Timestamp: time.Unix(0, trailer.Timestamp),
+			Acked:     trailer.Acked == 1,
+			Retries:   int(trailer.Retries),
+		},
+	}, nil
+}
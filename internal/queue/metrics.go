@@ -0,0 +1,65 @@
+package queue
+
+import "github.com/suman7383/go-queue/internal/metrics"
+
+// Message counters, by topic
+var (
+	enqueuedTotal     = metrics.NewCounter("goqueue_messages_enqueued_total", "Messages published to the topic.", "topic")
+	deliveredTotal    = metrics.NewCounter("goqueue_messages_delivered_total", "Deliveries to consumers, redeliveries included.", "topic")
+	ackedTotal        = metrics.NewCounter("goqueue_messages_acked_total", "Deliveries acknowledged by consumers.", "topic")
+	nackedTotal       = metrics.NewCounter("goqueue_messages_nacked_total", "Deliveries handed back by consumers.", "topic")
+	retriedTotal      = metrics.NewCounter("goqueue_messages_retried_total", "Deliveries requeued after their ack timed out.", "topic")
+	droppedTotal      = metrics.NewCounter("goqueue_messages_dropped_total", "Messages discarded without delivery, by reason.", "topic", "reason")
+	deadLetteredTotal = metrics.NewCounter("goqueue_messages_dead_lettered_total", "Messages moved to the dead-letter topic.", "topic")
+)
+
+// Topic state, refreshed on every scrape by CollectMetrics
+var (
+	pendingMessages  = metrics.NewGauge("goqueue_pending_messages", "Messages waiting for delivery, summed over consumer groups.", "topic")
+	inFlightMessages = metrics.NewGauge("goqueue_inflight_messages", "Messages delivered but not yet acked.", "topic")
+	walBytes         = metrics.NewGauge("goqueue_wal_bytes", "Bytes of WAL segments and snapshots on disk.", "topic")
+)
+
+var (
+	walFlushSeconds = metrics.NewHistogram("goqueue_wal_flush_seconds", "Time to write, flush and (if configured) fsync a WAL batch.",
+		metrics.ExponentialBuckets(0.0001, 4, 10), "topic")
+	walBatchEntries = metrics.NewHistogram("goqueue_wal_batch_entries", "Entries written per WAL batch.",
+		metrics.ExponentialBuckets(1, 2, 12), "topic")
+	messageAgeSeconds = metrics.NewHistogram("goqueue_message_age_seconds", "Time from publish to delivery.",
+		metrics.ExponentialBuckets(0.001, 4, 12), "topic")
+)
+
+// Series labelled with a topic, dropped when the topic is deleted
+var topicMetrics = []*metrics.Vec{
+	enqueuedTotal.Vec, deliveredTotal.Vec, ackedTotal.Vec, nackedTotal.Vec, retriedTotal.Vec, droppedTotal.Vec, deadLetteredTotal.Vec,
+	pendingMessages.Vec, inFlightMessages.Vec, walBytes.Vec,
+	walFlushSeconds.Vec, walBatchEntries.Vec, messageAgeSeconds.Vec,
+}
+
+func forgetTopicMetrics(name string) {
+	for _, v := range topicMetrics {
+		v.DeleteLabel("topic", name)
+	}
+}
+
+// CollectMetrics refreshes the gauges of every topic, call it before
+// the metrics are scraped
+func (r *TopicRegistry) CollectMetrics() {
+	r.mu.RLock()
+	topics := make([]*Topic, 0, len(r.topics))
+	for _, topic := range r.topics {
+		topics = append(topics, topic)
+	}
+	r.mu.RUnlock()
+
+	// Start over so deleted topics don't linger
+	pendingMessages.Reset()
+	inFlightMessages.Reset()
+	walBytes.Reset()
+	for _, topic := range topics {
+		stats := topic.Stats()
+		pendingMessages.Set(float64(stats.Pending+stats.Delayed), topic.Name)
+		inFlightMessages.Set(float64(stats.InFlight), topic.Name)
+		walBytes.Set(float64(topic.wal.Size()), topic.Name)
+	}
+}
@@ -2,38 +2,151 @@ package queue
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"math"
 	"os"
+	"slices"
 	"sync"
 	"time"
 )
 
+const (
+	DefaultSyncInterval = time.Second
+
+	DefaultWALChannelDepth  = 10000
+	MaxWALChannelDepth      = 1 << 20 // allocated upfront
+	DefaultWALBatchSize     = 100
+	DefaultWALFlushInterval = 50 * time.Millisecond
+)
+
 type WAL struct {
+	dir     string
 	file    *os.File
+	counter *countingWriter
 	writer  *bufio.Writer
 	topic   string
-	walChan chan LogEntry
+	walChan chan walRecord
+	sealCh  chan chan sealResult
 	wg      sync.WaitGroup
 	closeCh chan struct{}
+
+	closeMu sync.RWMutex // held for reading while appending
+	closed  bool
+
+	// active segment
+	segID     int64
+	segOpened time.Time
+	policy    segmentPolicy
+
+	durability   Durability
+	syncInterval time.Duration
+	unsynced     bool // written since the last fsync
+
+	batchSize     int
+	flushInterval time.Duration
+
+	healthMu sync.Mutex
+	failures int   // flushes failed in a row
+	lastErr  error // of the last failed flush
+	rollErr  error // of the last roll, if it failed
+	stopped  bool  // the writer goroutine exited
 }
 
-func NewWAL(topicName string) (*WAL, error) {
-	path := fmt.Sprintf("data/%s.wal", topicName)
-	os.MkdirAll("data", os.ModePerm)
+// sealResult answers Seal
+type sealResult struct {
+	segID int64
+	err   error
+}
 
-	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
+// walRecord is a group of entries waiting in walChan, with the commit
+// to complete once they are durable (nil if nobody waits for them).
+// Entries of one record are always written in the same batch.
+type walRecord struct {
+	entries []LogEntry
+	commit  *Commit
+}
+
+// Commit reports when an appended entry has been synced to disk
+type Commit struct {
+	done chan struct{}
+	err  error
+}
+
+// Wait blocks until the entry is synced. A nil Commit is done already.
+func (c *Commit) Wait() error {
+	if c == nil {
+		return nil
+	}
+	<-c.done
+	return c.err
+}
+
+// result reports whether c completed and its error, without waiting
+func (c *Commit) result() (bool, error) {
+	select {
+	case <-c.done:
+		return true, c.err
+	default:
+		return false, nil
+	}
+}
+
+func (c *Commit) complete(err error) {
+	if c == nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+}
+
+// countingWriter tracks how many bytes reached the segment file
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func NewWAL(topicName string, config TopicConfig) (*WAL, error) {
+	dir := topicDir(topicName)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 		return nil, err
 	}
 
 	w := &WAL{
-		file:    file,
-		writer:  bufio.NewWriterSize(file, 1<<20), // 1MB buffer
-		topic:   topicName,
-		walChan: make(chan LogEntry, 10000),
-		closeCh: make(chan struct{}),
+		dir:           dir,
+		topic:         topicName,
+		walChan:       make(chan walRecord, orDefault(config.WALChannelDepth, DefaultWALChannelDepth)),
+		sealCh:        make(chan chan sealResult),
+		closeCh:       make(chan struct{}),
+		policy:        newSegmentPolicy(config),
+		durability:    config.Durability,
+		syncInterval:  orDefault(config.SyncInterval, DefaultSyncInterval),
+		batchSize:     orDefault(config.WALBatchSize, DefaultWALBatchSize),
+		flushInterval: orDefault(config.WALFlushInterval, DefaultWALFlushInterval),
+	}
+
+	// Keep appending to the newest segment, or start the first one
+	ids, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	segID := int64(1)
+	if len(ids) > 0 {
+		segID = ids[len(ids)-1]
+	}
+	if err := w.openSegment(segID); err != nil {
+		return nil, err
 	}
 
 	// Start WAL writer goroutine
@@ -45,53 +158,168 @@ func NewWAL(topicName string) (*WAL, error) {
 
 // AppendEvent queues an entry for persistence (non-blocking if buffer is available).
 // Blocks if the channel is full to ensure no data loss.
-func (w *WAL) AppendEvent(eventType string, msg Message) error {
-	entry := LogEntry{
+// In DurabilityGroupCommit mode the returned Commit completes once the
+// entry is synced to disk, otherwise it is nil.
+func (w *WAL) AppendEvent(eventType string, msg Message) *Commit {
+	return w.Append(LogEntry{
 		Type:    eventType,
 		Message: msg,
+	})
+}
+
+// Append is AppendEvent for a fully built entry
+func (w *WAL) Append(entry LogEntry) *Commit {
+	return w.AppendBatch([]LogEntry{entry})
+}
+
+// AppendBatch queues entries to be written together, sharing one Commit.
+// Once the WAL is closed nothing is written and the Commit fails.
+func (w *WAL) AppendBatch(entries []LogEntry) *Commit {
+	w.closeMu.RLock()
+	defer w.closeMu.RUnlock()
+
+	if w.closed {
+		commit := &Commit{done: make(chan struct{})}
+		commit.complete(ErrTopicClosed)
+		return commit
 	}
-	w.walChan <- entry
-	return nil
+
+	record := walRecord{entries: entries}
+	if w.durability == DurabilityGroupCommit {
+		record.commit = &Commit{done: make(chan struct{})}
+	}
+
+	w.walChan <- record
+	return record.commit
+}
+
+// Returns v, or def if v isn't set
+func orDefault[T int | int64 | time.Duration](v, def T) T {
+	if v <= 0 {
+		return def
+	}
+	return v
 }
 
 // Background WAL writer
 func (w *WAL) runWriter() {
 	defer w.wg.Done()
+	defer func() {
+		w.healthMu.Lock()
+		w.stopped = true
+		w.healthMu.Unlock()
+	}()
 
-	batch := make([]LogEntry, 0, 1000)
+	batch := make([]walRecord, 0, w.batchSize)
 
 	flush := func() {
-		for _, e := range batch {
-			if err := w.encodeEntry(e); err != nil {
-				// In production: push to errChan or panic based on durability needs
-				log.Printf("[WAL ERROR] encode failed: %v\n", err)
+		start := time.Now()
+		entries := 0
+
+		var err error
+		for _, r := range batch {
+			entries += len(r.entries)
+			for _, e := range r.entries {
+				if werr := writeRecord(w.writer, e); werr != nil {
+					// In production: push to errChan or panic based on durability needs
+					log.Printf("[WAL ERROR] encode failed: %v\n", werr)
+					err = werr
+				}
+			}
+			if w.policy.full(w.segmentSize()) {
+				w.rollSegment() // keeps the segment on failure
+			}
+		}
+		if ferr := w.writer.Flush(); ferr != nil {
+			log.Printf("[WAL ERROR] flush failed: %v\n", ferr)
+			err = ferr
+		}
+		w.unsynced = true
+
+		// Group commit: one fsync covers the whole batch
+		if w.durability == DurabilityGroupCommit {
+			if serr := w.sync(); serr != nil {
+				err = serr
 			}
 		}
+		for _, r := range batch {
+			r.commit.complete(err)
+		}
 		batch = batch[:0]
-		w.writer.Flush()
+
+		w.recordFlush(err)
+		walFlushSeconds.Observe(time.Since(start).Seconds(), w.topic)
+		walBatchEntries.Observe(float64(entries), w.topic)
 	}
 
-	ticker := time.NewTicker(50 * time.Millisecond) // flush interval
+	// Pull in whatever is already queued without blocking
+	drain := func() {
+		for {
+			select {
+			case r, ok := <-w.walChan:
+				if !ok {
+					return
+				}
+				batch = append(batch, r)
+			default:
+				return
+			}
+		}
+	}
+
+	ticker := time.NewTicker(w.flushInterval)
 	defer ticker.Stop()
 
+	var syncTick <-chan time.Time
+	if w.durability == DurabilityInterval {
+		syncTicker := time.NewTicker(w.syncInterval)
+		defer syncTicker.Stop()
+		syncTick = syncTicker.C
+	}
+
 	for {
 		select {
-		case e, ok := <-w.walChan:
+		case r, ok := <-w.walChan:
 			if !ok {
 				if len(batch) > 0 {
 					flush()
 				}
 				return
 			}
-			batch = append(batch, e)
-			if len(batch) >= 100 { // batch size
+			batch = append(batch, r)
+
+			// Producers are waiting, commit everything queued right
+			// away; more entries pile up in walChan during the fsync
+			if w.durability == DurabilityGroupCommit {
+				drain()
+				flush()
+			} else if len(batch) >= w.batchSize {
 				flush()
 			}
 		case <-ticker.C:
 			if len(batch) > 0 {
 				flush()
 			}
+			if w.policy.expired(w.segmentSize(), w.segOpened) {
+				w.rollSegment() // keeps the segment on failure
+			}
+		case <-syncTick:
+			if err := w.sync(); err != nil {
+				log.Printf("[WAL ERROR] fsync failed: %v\n", err)
+			}
+		case reply := <-w.sealCh:
+			// Everything appended before Seal was called is already in
+			// walChan, pull it in so it lands in the sealed segment
+			drain()
+			flush()
+			var err error
+			if w.segmentSize() > 0 {
+				err = w.rollSegment()
+			}
+			reply <- sealResult{segID: w.segID, err: err}
 		case <-w.closeCh:
+			// Close races walChan against closeCh, keep what was queued
+			drain()
 			if len(batch) > 0 {
 				flush()
 			}
@@ -100,31 +328,307 @@ func (w *WAL) runWriter() {
 	}
 }
 
+// Flushes failing in a row after which the WAL is unhealthy
+const walFailureThreshold = 3
+
+func (w *WAL) recordFlush(err error) {
+	w.healthMu.Lock()
+	defer w.healthMu.Unlock()
+
+	if err == nil {
+		w.failures = 0
+		return
+	}
+	w.failures++
+	w.lastErr = err
+}
+
+// Health reports why the WAL can't persist entries, nil if it can
+func (w *WAL) Health() error {
+	w.healthMu.Lock()
+	defer w.healthMu.Unlock()
+
+	if w.stopped {
+		return errors.New("WAL writer stopped")
+	}
+	if w.rollErr != nil {
+		return fmt.Errorf("can't roll to a new segment: %w", w.rollErr)
+	}
+	if w.failures >= walFailureThreshold {
+		return fmt.Errorf("%d WAL flushes failed in a row: %w", w.failures, w.lastErr)
+	}
+	return nil
+}
+
+// fsyncs the active segment if anything was written since the last sync
+func (w *WAL) sync() error {
+	if !w.unsynced {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.unsynced = false
+	return nil
+}
+
+// Bytes written to the active segment, including what is still buffered
+func (w *WAL) segmentSize() int64 {
+	return w.counter.n + int64(w.writer.Buffered())
+}
+
+// Opens (or creates) segment id for appending and makes it active
+func (w *WAL) openSegment(id int64) error {
+	file, err := os.OpenFile(segmentPath(w.dir, id), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.counter = &countingWriter{w: file, n: info.Size()}
+	if w.writer == nil {
+		w.writer = bufio.NewWriterSize(w.counter, 1<<20) // 1MB buffer
+	} else {
+		w.writer.Reset(w.counter)
+	}
+	w.segID = id
+	w.segOpened = time.Now()
+	w.unsynced = false
+
+	return nil
+}
+
+// rollSegment seals the active segment and starts the next one. If the
+// next segment can't be opened (eg: ENOSPC, EMFILE) it keeps appending
+// to the active one and the WAL reports unhealthy until a roll succeeds.
+// Only called from the writer goroutine.
+func (w *WAL) rollSegment() error {
+	err := w.roll()
+	if err != nil {
+		log.Printf("[WAL ERROR] Topic: %s | roll failed, still appending to segment %d: %v\n", w.topic, w.segID, err)
+	}
+
+	w.healthMu.Lock()
+	w.rollErr = err
+	w.healthMu.Unlock()
+
+	return err
+}
+
+func (w *WAL) roll() error {
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	w.unsynced = true
+
+	// The sealed segment is never written again, sync it once for good
+	if w.durability != DurabilityNone {
+		if err := w.sync(); err != nil {
+			log.Printf("[WAL ERROR] fsync before roll failed: %v\n", err)
+		}
+	}
+
+	sealed := w.file
+	if err := w.openSegment(w.segID + 1); err != nil {
+		return err
+	}
+	sealed.Close()
+
+	log.Printf("[WAL] Topic: %s | rolled to segment %d\n", w.topic, w.segID)
+	return nil
+}
+
+// Seal makes sure every entry appended so far is written to a segment
+// older than the returned id, rolling the active segment if needed.
+// Fails if the segment couldn't be rolled.
+// The caller must stop concurrent appends until Seal returns.
+func (w *WAL) Seal() (int64, error) {
+	reply := make(chan sealResult)
+	w.sealCh <- reply
+	result := <-reply
+	return result.segID, result.err
+}
+
+// RemoveSegmentsBefore deletes the sealed segments older than id
+func (w *WAL) RemoveSegmentsBefore(id int64) error {
+	ids, err := listSegments(w.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, segID := range ids {
+		if segID >= id {
+			break
+		}
+		if err := os.Remove(segmentPath(w.dir, segID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Size returns the bytes the topic's segments and snapshots take on disk.
+// Entries still buffered by the writer are not counted.
+func (w *WAL) Size() int64 {
+	files, err := os.ReadDir(w.dir)
+	if err != nil {
+		return 0
+	}
+
+	var size int64
+	for _, file := range files {
+		if info, err := file.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+
+	return size
+}
+
+// CorruptRecord is a WAL record that failed verification during replay,
+// or a run of bytes skipped to find the next valid record once a bad
+// length made the record boundaries unreadable
+type CorruptRecord struct {
+	Segment string `json:"segment"`
+	Offset  int64  `json:"offset"`
+	End     int64  `json:"end"` // where the next record starts
+	Reason  string `json:"reason"`
+}
+
+// Replay decodes every entry of the segments starting at id from, in order.
+// Records failing verification are skipped and returned. A torn record at
+// the end of the newest segment (a write cut short by a crash) is
+// truncated away instead, as long as no valid record follows it.
+func (w *WAL) Replay(from int64, fn func(LogEntry)) ([]CorruptRecord, error) {
+	ids, err := listSegments(w.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var corrupt []CorruptRecord
+	for i, id := range ids {
+		if id < from {
+			continue // covered by a snapshot
+		}
+
+		path := segmentPath(w.dir, id)
+		bad, tail, err := replaySegment(path, fn)
+		if err != nil {
+			return corrupt, err
+		}
+		corrupt = append(corrupt, bad...)
+
+		if len(tail) == 0 {
+			continue
+		}
+		if i < len(ids)-1 {
+			// Sealed segments were complete when they rolled
+			corrupt = append(corrupt, tail...)
+			continue
+		}
+
+		// Stop appending to the torn segment before cutting it
+		if _, err := w.Seal(); err != nil {
+			return corrupt, err
+		}
+		if err := os.Truncate(path, tail[0].Offset); err != nil {
+			return corrupt, err
+		}
+		log.Printf("[Recovery] truncated torn tail of %s at offset %d (%s)\n", path, tail[0].Offset, tail[0].Reason)
+	}
+
+	return corrupt, nil
+}
+
+// replaySegment feeds every valid entry of the segment at path to fn.
+// Bad records followed by a valid one are returned in corrupt, the
+// trailing run of bad records (if any) in tail. A record failing its
+// checksum may have a bad length too, so reading resumes at the next
+// record whose checksum holds; with none left, the rest of the segment
+// is the tail.
+func replaySegment(path string, fn func(LogEntry)) (corrupt, tail []CorruptRecord, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size := info.Size()
+	reader := &recordReader{r: bufio.NewReader(file), size: size}
+	for {
+		entry, offset, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if fatalRecordErr(err) || errors.Is(err, errChecksum) {
+			// The length can't be trusted, look for where records resume
+			next, findErr := findRecord(file, offset+1, size)
+			if findErr != nil {
+				return corrupt, tail, findErr
+			}
+			if next < 0 {
+				// Nothing readable follows, a torn write
+				tail = append(tail, CorruptRecord{Segment: path, Offset: offset, End: size, Reason: err.Error()})
+				break
+			}
+
+			tail = append(tail, CorruptRecord{Segment: path, Offset: offset, End: next,
+				Reason: fmt.Sprintf("%v, skipped %d bytes", err, next-offset)})
+			reader = &recordReader{r: bufio.NewReader(io.NewSectionReader(file, next, size-next)), offset: next, size: size}
+			continue
+		}
+		if err != nil {
+			tail = append(tail, CorruptRecord{Segment: path, Offset: offset, End: reader.offset, Reason: err.Error()})
+			continue
+		}
+
+		// A valid record proves the bad ones before it weren't torn writes
+		corrupt = append(corrupt, tail...)
+		tail = nil
+
+		fn(entry)
+	}
+
+	return corrupt, tail, nil
+}
+
 // encodeEntry writes a single LogEntry in binary format.
-func (w *WAL) encodeEntry(entry LogEntry) error {
+func encodeEntry(writer io.Writer, entry LogEntry) error {
 	// Encode Type as length-prefixed string
-	if err := binary.Write(w.writer, binary.LittleEndian, uint16(len(entry.Type))); err != nil {
+	if err := binary.Write(writer, binary.LittleEndian, uint16(len(entry.Type))); err != nil {
 		return err
 	}
-	if _, err := w.writer.WriteString(entry.Type); err != nil {
+	if _, err := io.WriteString(writer, entry.Type); err != nil {
 		return err
 	}
 
 	// Encode Message ID
-	if err := binary.Write(w.writer, binary.LittleEndian, int64(entry.Message.ID)); err != nil {
+	if err := binary.Write(writer, binary.LittleEndian, int64(entry.Message.ID)); err != nil {
 		return err
 	}
 
-	// Encode Payload as length-prefixed string
-	if err := binary.Write(w.writer, binary.LittleEndian, uint32(len(entry.Message.Payload))); err != nil {
+	// Encode Payload as length-prefixed bytes
+	if err := binary.Write(writer, binary.LittleEndian, uint32(len(entry.Message.Payload))); err != nil {
 		return err
 	}
-	if _, err := w.writer.WriteString(entry.Message.Payload); err != nil {
+	if _, err := writer.Write(entry.Message.Payload); err != nil {
 		return err
 	}
 
 	// Encode Timestamp (UnixNano)
-	if err := binary.Write(w.writer, binary.LittleEndian, entry.Message.Timestamp.UnixNano()); err != nil {
+	if err := binary.Write(writer, binary.LittleEndian, entry.Message.Timestamp.UnixNano()); err != nil {
 		return err
 	}
 
@@ -133,21 +637,420 @@ func (w *WAL) encodeEntry(entry LogEntry) error {
 	if entry.Message.Acked {
 		acked = 1
 	}
-	if err := binary.Write(w.writer, binary.LittleEndian, acked); err != nil {
+	if err := binary.Write(writer, binary.LittleEndian, acked); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, int32(entry.Message.Retries)); err != nil {
+		return err
+	}
+
+	// Encode DeadLetter (presence flag + fields)
+	if err := encodeDeadLetter(writer, entry.Message.DeadLetter); err != nil {
+		return err
+	}
+
+	// Encode Headers (since version 2)
+	if err := encodeHeaders(writer, entry.Message.Headers); err != nil {
+		return err
+	}
+
+	return encodeExtensions(writer, entry)
+}
+
+func encodeDeadLetter(writer io.Writer, dl *DeadLetter) error {
+	if dl == nil {
+		return binary.Write(writer, binary.LittleEndian, uint8(0))
+	}
+	if err := binary.Write(writer, binary.LittleEndian, uint8(1)); err != nil {
 		return err
 	}
-	if err := binary.Write(w.writer, binary.LittleEndian, int32(entry.Message.Retries)); err != nil {
+	if err := binary.Write(writer, binary.LittleEndian, uint16(len(dl.Topic))); err != nil {
 		return err
 	}
+	if _, err := io.WriteString(writer, dl.Topic); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, dl.ID); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, int32(dl.Retries)); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, uint16(len(dl.Reason))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, dl.Reason)
+	return err
+}
+
+// Headers are a uint16 count followed by each key (uint16 length +
+// string) and value (uint32 length + string), in key order
+func encodeHeaders(writer io.Writer, headers map[string]string) error {
+	if len(headers) > math.MaxUint16 {
+		return errors.New("too many headers")
+	}
+	if err := binary.Write(writer, binary.LittleEndian, uint16(len(headers))); err != nil {
+		return err
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(headers)) {
+		if len(key) > math.MaxUint16 {
+			return errors.New("header name too long")
+		}
+		if err := binary.Write(writer, binary.LittleEndian, uint16(len(key))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(writer, key); err != nil {
+			return err
+		}
+		if err := binary.Write(writer, binary.LittleEndian, uint32(len(headers[key]))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(writer, headers[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fields added after the fixed layout above are written as optional
+// tagged extensions: tag uint8, length uvarint, value. Empty fields are
+// left out, and decoders skip tags they don't know, so records written
+// before a field existed still decode.
+const (
+	extGroup           uint8 = 1  // LogEntry.Group
+	extDeadLetterGroup uint8 = 2  // Message.DeadLetter.Group
+	extDueAt           uint8 = 3  // LogEntry.DueAt, UnixNano int64
+	extReceipt         uint8 = 4  // Message.Receipt
+	extDeliverAt       uint8 = 5  // Message.DeliverAt, UnixNano int64
+	extExpiresAt       uint8 = 6  // Message.ExpiresAt, UnixNano int64
+	extDedupKey        uint8 = 7  // Message.DedupKey
+	extEnqueuedAt      uint8 = 8  // Message.EnqueuedAt, UnixNano int64
+	extPriority        uint8 = 9  // Message.Priority, varint
+	extMessageGroup    uint8 = 10 // Message.MessageGroup
+)
+
+func encodeExtensions(writer io.Writer, entry LogEntry) error {
+	if err := writeExtension(writer, extGroup, []byte(entry.Group)); err != nil {
+		return err
+	}
+	if dl := entry.Message.DeadLetter; dl != nil {
+		if err := writeExtension(writer, extDeadLetterGroup, []byte(dl.Group)); err != nil {
+			return err
+		}
+	}
+	if err := writeExtension(writer, extReceipt, []byte(entry.Message.Receipt)); err != nil {
+		return err
+	}
+	if err := writeTimeExtension(writer, extDueAt, entry.DueAt); err != nil {
+		return err
+	}
+	if err := writeTimeExtension(writer, extDeliverAt, entry.Message.DeliverAt); err != nil {
+		return err
+	}
+	if err := writeTimeExtension(writer, extExpiresAt, entry.Message.ExpiresAt); err != nil {
+		return err
+	}
+	if err := writeExtension(writer, extDedupKey, []byte(entry.Message.DedupKey)); err != nil {
+		return err
+	}
+	if err := writeTimeExtension(writer, extEnqueuedAt, entry.Message.EnqueuedAt); err != nil {
+		return err
+	}
+	if err := writeExtension(writer, extMessageGroup, []byte(entry.Message.MessageGroup)); err != nil {
+		return err
+	}
+	if priority := entry.Message.Priority; priority != 0 {
+		if err := writeExtension(writer, extPriority, binary.AppendVarint(nil, int64(priority))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeExtension(writer io.Writer, tag uint8, value []byte) error {
+	if len(value) == 0 {
+		return nil
+	}
+
+	header := make([]byte, 1+binary.MaxVarintLen64)
+	header[0] = tag
+	n := binary.PutUvarint(header[1:], uint64(len(value)))
+	if _, err := writer.Write(header[:1+n]); err != nil {
+		return err
+	}
+	_, err := writer.Write(value)
+	return err
+}
+
+// Writes t as UnixNano, nothing if t is zero
+func writeTimeExtension(writer io.Writer, tag uint8, t time.Time) error {
+	if t.IsZero() {
+		return nil
+	}
+
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], uint64(t.UnixNano()))
+	return writeExtension(writer, tag, value[:])
+}
+
+func readTimeExtension(value []byte) (time.Time, error) {
+	if len(value) != 8 {
+		return time.Time{}, errors.New("invalid time extension")
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(value))), nil
+}
+
+// Reads extensions until the end of the record body
+func decodeExtensions(reader *bytes.Reader, entry *LogEntry) error {
+	for reader.Len() > 0 {
+		tag, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return err
+		}
+		if length > uint64(reader.Len()) {
+			return io.ErrUnexpectedEOF
+		}
+
+		value := make([]byte, length)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return err
+		}
+
+		switch tag {
+		case extGroup:
+			entry.Group = string(value)
+		case extDeadLetterGroup:
+			if entry.Message.DeadLetter != nil {
+				entry.Message.DeadLetter.Group = string(value)
+			}
+		case extReceipt:
+			entry.Message.Receipt = string(value)
+		case extDueAt:
+			if entry.DueAt, err = readTimeExtension(value); err != nil {
+				return err
+			}
+		case extDeliverAt:
+			if entry.Message.DeliverAt, err = readTimeExtension(value); err != nil {
+				return err
+			}
+		case extExpiresAt:
+			if entry.Message.ExpiresAt, err = readTimeExtension(value); err != nil {
+				return err
+			}
+		case extDedupKey:
+			entry.Message.DedupKey = string(value)
+		case extMessageGroup:
+			entry.Message.MessageGroup = string(value)
+		case extPriority:
+			priority, n := binary.Varint(value)
+			if n <= 0 {
+				return errors.New("invalid priority")
+			}
+			entry.Message.Priority = int(priority)
+		case extEnqueuedAt:
+			if entry.Message.EnqueuedAt, err = readTimeExtension(value); err != nil {
+				return err
+			}
+		}
+	}
 
 	return nil
 }
 
-// Close gracefully shuts down the WAL writer and flushes everything.
+// decodeEntry reads a single LogEntry written by encodeEntry.
+// reader holds exactly one record body of the given record version.
+func decodeEntry(reader *bytes.Reader, version uint8) (LogEntry, error) {
+	// --- Decode Type (uint16 length + string) ---
+	var typeLen uint16
+	if err := binary.Read(reader, binary.LittleEndian, &typeLen); err != nil {
+		return LogEntry{}, err
+	}
+
+	typeBytes := make([]byte, typeLen)
+	if _, err := io.ReadFull(reader, typeBytes); err != nil {
+		return LogEntry{}, err
+	}
+
+	// --- Decode Message ID ---
+	var id int64
+	if err := binary.Read(reader, binary.LittleEndian, &id); err != nil {
+		return LogEntry{}, err
+	}
+
+	// --- Decode Payload (uint32 length + bytes) ---
+	var payloadLen uint32
+	if err := binary.Read(reader, binary.LittleEndian, &payloadLen); err != nil {
+		return LogEntry{}, err
+	}
+
+	payloadBytes := make([]byte, payloadLen)
+	if _, err := io.ReadFull(reader, payloadBytes); err != nil {
+		return LogEntry{}, err
+	}
+
+	// --- Decode Timestamp ---
+	var ts int64
+	if err := binary.Read(reader, binary.LittleEndian, &ts); err != nil {
+		return LogEntry{}, err
+	}
+
+	// --- Decode Acked ---
+	var acked uint8
+	if err := binary.Read(reader, binary.LittleEndian, &acked); err != nil {
+		return LogEntry{}, err
+	}
+
+	// --- Decode Retries ---
+	var retries int32
+	if err := binary.Read(reader, binary.LittleEndian, &retries); err != nil {
+		return LogEntry{}, err
+	}
+
+	// --- Decode DeadLetter ---
+	dl, err := decodeDeadLetter(reader)
+	if err != nil {
+		return LogEntry{}, err
+	}
+
+	// --- Decode Headers (version 1 records have none) ---
+	var headers map[string]string
+	if version >= 2 {
+		if headers, err = decodeHeaders(reader); err != nil {
+			return LogEntry{}, err
+		}
+	}
+
+	entry := LogEntry{
+		Type: string(typeBytes),
+		Message: Message{
+			ID:         id,
+			Payload:    payloadBytes,
+			Headers:    headers,
+			Timestamp:  time.Unix(0, ts),
+			Acked:      acked == 1,
+			Retries:    int(retries),
+			DeadLetter: dl,
+		},
+	}
+
+	if err := decodeExtensions(reader, &entry); err != nil {
+		return LogEntry{}, err
+	}
+
+	return entry, nil
+}
+
+func decodeHeaders(reader *bytes.Reader) (map[string]string, error) {
+	var count uint16
+	if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	headers := make(map[string]string, count)
+	for range count {
+		var keyLen uint16
+		if err := binary.Read(reader, binary.LittleEndian, &keyLen); err != nil {
+			return nil, err
+		}
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(reader, key); err != nil {
+			return nil, err
+		}
+
+		var valueLen uint32
+		if err := binary.Read(reader, binary.LittleEndian, &valueLen); err != nil {
+			return nil, err
+		}
+		if int64(valueLen) > int64(reader.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		value := make([]byte, valueLen)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+
+		headers[string(key)] = string(value)
+	}
+
+	return headers, nil
+}
+
+func decodeDeadLetter(reader io.Reader) (*DeadLetter, error) {
+	var present uint8
+	if err := binary.Read(reader, binary.LittleEndian, &present); err != nil {
+		return nil, err
+	}
+	if present == 0 {
+		return nil, nil
+	}
+
+	var topicLen uint16
+	if err := binary.Read(reader, binary.LittleEndian, &topicLen); err != nil {
+		return nil, err
+	}
+	topic := make([]byte, topicLen)
+	if _, err := io.ReadFull(reader, topic); err != nil {
+		return nil, err
+	}
+
+	var id int64
+	if err := binary.Read(reader, binary.LittleEndian, &id); err != nil {
+		return nil, err
+	}
+
+	var retries int32
+	if err := binary.Read(reader, binary.LittleEndian, &retries); err != nil {
+		return nil, err
+	}
+
+	var reasonLen uint16
+	if err := binary.Read(reader, binary.LittleEndian, &reasonLen); err != nil {
+		return nil, err
+	}
+	reason := make([]byte, reasonLen)
+	if _, err := io.ReadFull(reader, reason); err != nil {
+		return nil, err
+	}
+
+	return &DeadLetter{
+		Topic:   string(topic),
+		ID:      id,
+		Retries: int(retries),
+		Reason:  string(reason),
+	}, nil
+}
+
+// Close gracefully shuts down the WAL writer, flushes everything and
+// fsyncs the active segment whatever the durability.
 func (w *WAL) Close() {
+	w.closeMu.Lock()
+	if w.closed {
+		w.closeMu.Unlock()
+		return
+	}
+	w.closed = true
 	close(w.closeCh)
 	close(w.walChan)
+	w.closeMu.Unlock()
+
 	w.wg.Wait()
-	w.writer.Flush()
+	if err := w.writer.Flush(); err != nil {
+		log.Printf("[WAL ERROR] final flush failed: %v\n", err)
+	}
+	w.unsynced = true
+	if err := w.sync(); err != nil {
+		log.Printf("[WAL ERROR] final fsync failed: %v\n", err)
+	}
 	w.file.Close()
 }
@@ -0,0 +1,579 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Writes n framed enqueue records into a fresh segment, returning its path
+// and the offset each record starts at
+func writeTestSegment(t *testing.T, n int) (string, []int64) {
+	t.Helper()
+
+	dir := topicDir("test")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	path := segmentPath(dir, 1)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	offsets := make([]int64, n)
+	for i := range n {
+		info, _ := file.Stat()
+		offsets[i] = info.Size()
+
+		entry := LogEntry{Type: "enqueue", Message: Message{ID: int64(i + 1), Payload: []byte(fmt.Sprintf("message %d", i+1))}}
+		if err := writeRecord(file, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path, offsets
+}
+
+func openTestWAL(t *testing.T) *WAL {
+	t.Helper()
+
+	wal, err := NewWAL("test", TopicConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(wal.Close)
+
+	return wal
+}
+
+func TestReplayTruncatesTornTail(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	path, offsets := writeTestSegment(t, 3)
+
+	// Cut the last record in half, as a crash mid-write would
+	info, _ := os.Stat(path)
+	torn := offsets[2] + (info.Size()-offsets[2])/2
+	if err := os.Truncate(path, torn); err != nil {
+		t.Fatal(err)
+	}
+
+	wal := openTestWAL(t)
+
+	var ids []int64
+	corrupt, err := wal.Replay(0, func(e LogEntry) { ids = append(ids, e.Message.ID) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(corrupt) != 0 {
+		t.Fatalf("torn tail reported as corruption: %+v", corrupt)
+	}
+	if fmt.Sprint(ids) != "[1 2]" {
+		t.Fatalf("replayed %v, want [1 2]", ids)
+	}
+
+	info, _ = os.Stat(path)
+	if info.Size() != offsets[2] {
+		t.Fatalf("segment is %d bytes, want it truncated to %d", info.Size(), offsets[2])
+	}
+}
+
+func TestReplayReportsCorruptionMidLog(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	path, offsets := writeTestSegment(t, 3)
+
+	// Flip a bit inside the body of the second record
+	data, _ := os.ReadFile(path)
+	data[offsets[1]+recordHeaderSize+5] ^= 0x01
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wal := openTestWAL(t)
+
+	var ids []int64
+	corrupt, err := wal.Replay(0, func(e LogEntry) { ids = append(ids, e.Message.ID) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(ids) != "[1 3]" {
+		t.Fatalf("replayed %v, want [1 3]", ids)
+	}
+	if len(corrupt) != 1 || corrupt[0].Offset != offsets[1] || corrupt[0].Segment != path {
+		t.Fatalf("corruption reported as %+v, want %s at offset %d", corrupt, path, offsets[1])
+	}
+
+	// Nothing may be cut away when valid records follow the bad one
+	info, _ := os.Stat(path)
+	if info.Size() != int64(len(data)) {
+		t.Fatalf("segment shrank from %d to %d bytes", len(data), info.Size())
+	}
+}
+
+func TestReplayResumesAfterBadLength(t *testing.T) {
+	lengths := map[string]uint32{
+		"zero":         0,
+		"over the max": maxRecordSize + 1,
+		"past EOF":     1 << 20,
+		"too short":    4,
+	}
+	for name, length := range lengths {
+		t.Run(name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+
+			path, offsets := writeTestSegment(t, 10)
+			data, _ := os.ReadFile(path)
+			binary.LittleEndian.PutUint32(data[offsets[2]:], length)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			wal := openTestWAL(t)
+
+			var ids []int64
+			corrupt, err := wal.Replay(0, func(e LogEntry) { ids = append(ids, e.Message.ID) })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(ids) != "[1 2 4 5 6 7 8 9 10]" {
+				t.Fatalf("replayed %v, want every record but 3", ids)
+			}
+			if len(corrupt) != 1 || corrupt[0].Offset != offsets[2] || corrupt[0].End != offsets[3] {
+				t.Fatalf("corruption reported as %+v, want offsets %d to %d", corrupt, offsets[2], offsets[3])
+			}
+			if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+				t.Fatalf("segment shrank from %d to %d bytes", len(data), info.Size())
+			}
+		})
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := TopicConfig{AckTimeout: time.Minute, CheckpointInterval: -1}
+	topic := openTopic(t, "test", config)
+	for i := range 5 {
+		topic.Enqueue(fmt.Sprintf("message %d", i+1))
+	}
+	msg, _ := topic.Dequeue(DefaultGroup)
+	topic.Acknowledge(DefaultGroup, msg.Receipt)
+	topic.Dequeue(DefaultGroup)
+
+	if err := topic.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	topic.wal.Close()
+
+	snap, ok := loadLatestSnapshot(topicDir("test"))
+	if !ok {
+		t.Fatal("no snapshot written")
+	}
+	// subscribe + 3 pending + 1 in flight
+	if snap.nextID != 6 || len(snap.entries) != 5 {
+		t.Fatalf("snapshot has nextID %d and %d entries, want 6 and 5", snap.nextID, len(snap.entries))
+	}
+}
+
+func TestRecordVersionsDecode(t *testing.T) {
+	// A version 1 record, written before headers existed
+	v1, _ := hex.DecodeString("32000000eb01833f010700656e717565756507000000000000000500000068656c6c6f00002a36fe9c9717000200000000010762696c6c696e67")
+
+	rr := recordReader{r: bytes.NewReader(v1), size: int64(len(v1))}
+	entry, _, err := rr.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Group != "billing" || entry.Message.ID != 7 || string(entry.Message.Payload) != "hello" || entry.Message.Retries != 2 {
+		t.Fatalf("decoded v1 record as %+v", entry)
+	}
+
+	// Current records carry headers and binary payloads
+	want := LogEntry{Type: "enqueue", Message: Message{
+		ID:      8,
+		Payload: []byte{0, 0xff, '\n', 0x80},
+		Headers: map[string]string{"content-type": "application/octet-stream", "trace-id": "abc"},
+	}}
+	var buf bytes.Buffer
+	if err := writeRecord(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = recordReader{r: &buf, size: int64(buf.Len())}
+	got, _, err := rr.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Message.Payload, want.Message.Payload) || !maps.Equal(got.Message.Headers, want.Message.Headers) {
+		t.Fatalf("round trip got payload %q headers %v", got.Message.Payload, got.Message.Headers)
+	}
+}
+
+func TestSegmentsRollAndReplayInOrder(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.SegmentMaxBytes = 256
+	topic := openTopic(t, "orders", config)
+	for i := range 20 {
+		topic.Enqueue(fmt.Sprintf("message %d", i+1))
+	}
+	topic = restart(t, topic)
+	defer topic.Close()
+
+	ids, err := listSegments(topicDir("orders"))
+	if err != nil || len(ids) < 2 {
+		t.Fatalf("got segments %v (%v), want several", ids, err)
+	}
+	for i, id := range ids {
+		if id != int64(i+1) {
+			t.Fatalf("got segments %v, want them numbered from 1", ids)
+		}
+	}
+
+	expectIDs(t, topic, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20})
+}
+
+func TestFailedRollKeepsAppending(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.SegmentMaxBytes = 256
+	topic := openTopic(t, "orders", config)
+
+	// A directory in the way of segment 2 can't be opened for writing
+	if err := os.Mkdir(segmentPath(topicDir("orders"), 2), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 20 {
+		topic.Enqueue(fmt.Sprintf("message %d", i+1))
+	}
+	if err := topic.Checkpoint(); err == nil {
+		t.Fatal("checkpoint succeeded without rolling the segment")
+	}
+	if err := topic.Health(); err == nil {
+		t.Fatal("failed roll not reported")
+	}
+
+	topic = restart(t, topic)
+	defer topic.Close()
+	expectIDs(t, topic, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20})
+}
+
+// Writes entries in the format of the single-file log used before segments
+func writeLegacyWAL(t *testing.T, path string, entries []LogEntry) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		binary.Write(&buf, binary.LittleEndian, uint16(len(e.Type)))
+		buf.WriteString(e.Type)
+		binary.Write(&buf, binary.LittleEndian, e.Message.ID)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(e.Message.Payload)))
+		buf.Write(e.Message.Payload)
+		binary.Write(&buf, binary.LittleEndian, e.Message.Timestamp.UnixNano())
+		binary.Write(&buf, binary.LittleEndian, uint8(0))
+		binary.Write(&buf, binary.LittleEndian, int32(e.Message.Retries))
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyWALMigrates(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	if err := os.Mkdir(DataDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	msg := func(id int64) Message {
+		return Message{ID: id, Payload: []byte(fmt.Sprint("message ", id)), Timestamp: time.Now()}
+	}
+	writeLegacyWAL(t, filepath.Join(DataDir, "orders.wal"), []LogEntry{
+		{Type: "enqueue", Message: msg(1)},
+		{Type: "enqueue", Message: msg(2)},
+		{Type: "enqueue", Message: msg(3)},
+		{Type: "deliver", Message: msg(1)},
+		{Type: "ack", Message: msg(1)},
+		{Type: "deliver", Message: msg(2)},
+	})
+
+	for range 2 { // the second start finds nothing left to migrate
+		registry := NewTopicRegistry(orderConfig)
+		registry.LoadTopicFromDisk(orderConfig)
+		topic := registry.GetTopic("orders")
+		if topic == nil {
+			t.Fatal("legacy topic not loaded")
+		}
+		if stats := topic.Stats(); stats.Pending != 1 || stats.InFlight != 1 {
+			t.Fatalf("got %+v, want 1 pending and 1 in flight", stats)
+		}
+		registry.Close()
+	}
+
+	if _, err := os.Stat(filepath.Join(DataDir, "orders.wal.migrated")); err != nil {
+		t.Fatalf("legacy log not kept aside: %v", err)
+	}
+
+	registry := NewTopicRegistry(orderConfig)
+	registry.LoadTopicFromDisk(orderConfig)
+	defer registry.Close()
+	topic := registry.GetTopic("orders")
+	expectIDs(t, topic, []int64{3})
+	if id, _ := topic.Enqueue("new"); id != 4 {
+		t.Fatalf("new message got ID %d, want 4", id)
+	}
+}
+
+// IDs of the enqueue records in the first segment of topic
+func persistedIDs(t *testing.T, topic string) []int64 {
+	t.Helper()
+
+	var ids []int64
+	_, _, err := replaySegment(segmentPath(topicDir(topic), 1), func(e LogEntry) {
+		if e.Type == "enqueue" {
+			ids = append(ids, e.Message.ID)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestGroupCommitQueuesOnlyDurableMessages(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.Durability = DurabilityGroupCommit
+	config.WALFlushInterval = time.Hour // only the commit flushes
+	topic := openTopic(t, "orders", config)
+	defer topic.Close()
+
+	// Durable by the time the publish returns, and queued in ID order
+	// however the concurrent commits complete
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := topic.Enqueue("payload"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if ids := persistedIDs(t, "orders"); len(ids) != 20 {
+		t.Fatalf("%d of 20 messages on disk once published", len(ids))
+	}
+	expectIDs(t, topic, []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20})
+
+	// A failed commit is reported and its message never delivered
+	topic.wal.file.Close()
+	if _, err := topic.EnqueueMessages([]Message{{Payload: []byte("lost"), DedupKey: "order-1"}}); err == nil {
+		t.Fatal("publish succeeded without reaching the disk")
+	}
+	if msg, ok := topic.Dequeue(DefaultGroup); ok {
+		t.Fatalf("delivered message %d whose commit failed", msg.ID)
+	}
+
+	// so a retry with the same key isn't taken for a duplicate
+	topic.mu.Lock()
+	_, _, remembered := topic.dedup.lookup("order-1", time.Now())
+	topic.mu.Unlock()
+	if remembered {
+		t.Fatal("dedup key of a failed publish still remembered")
+	}
+}
+
+func TestDuplicateWaitsForOriginalCommit(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.Durability = DurabilityGroupCommit
+	topic := openTopic(t, "orders", config)
+	defer topic.Close()
+
+	for _, syncErr := range []error{nil, errors.New("fsync failed")} {
+		key := fmt.Sprintf("order-%v", syncErr)
+
+		// The original publish, still being synced
+		commit := &Commit{done: make(chan struct{})}
+		topic.mu.Lock()
+		original := topic.nextID
+		topic.nextID++
+		topic.dedup.add(key, original, time.Now())
+		topic.dedup.committing(key, original, commit)
+		topic.mu.Unlock()
+
+		// The producer retries before it got an answer
+		answered := make(chan int64, 1)
+		go func() {
+			ids, err := topic.EnqueueMessages([]Message{{Payload: []byte("retry"), DedupKey: key}})
+			if err != nil {
+				t.Error(err)
+			}
+			answered <- ids[0]
+		}()
+		select {
+		case id := <-answered:
+			t.Fatalf("duplicate answered with ID %d before the original was synced", id)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		commit.complete(syncErr)
+		id := <-answered
+		if syncErr == nil {
+			if id != original {
+				t.Fatalf("got ID %d, want the original %d", id, original)
+			}
+			continue
+		}
+
+		// The original is lost, the retry takes its place
+		if id == original {
+			t.Fatalf("got the ID %d of a publish that failed", id)
+		}
+		if msg, ok := topic.Dequeue(DefaultGroup); !ok || msg.ID != id || string(msg.Payload) != "retry" {
+			t.Fatalf("got message %+v (ok=%v), want the retry as ID %d", msg, ok, id)
+		}
+	}
+}
+
+func TestIntervalDurabilityPersistsInBackground(t *testing.T) {
+	for _, durability := range []Durability{DurabilityNone, DurabilityInterval} {
+		t.Run(string(durability), func(t *testing.T) {
+			t.Chdir(t.TempDir())
+
+			config := orderConfig
+			config.Durability = durability
+			config.SyncInterval = 10 * time.Millisecond
+			config.WALFlushInterval = 10 * time.Millisecond
+			topic := openTopic(t, "orders", config)
+			defer topic.Close()
+
+			// Deliverable right away, on disk shortly after
+			topic.EnqueueBatch([]string{"a", "b"})
+			expectIDs(t, topic, []int64{1, 2})
+
+			deadline := time.Now().Add(5 * time.Second)
+			for len(persistedIDs(t, "orders")) < 2 {
+				if time.Now().After(deadline) {
+					t.Fatal("messages never written without a close")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestCheckpointsDuringGroupCommitsLoseNothing(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.Durability = DurabilityGroupCommit
+	topic := openTopic(t, "orders", config)
+
+	const producers, each = 4, 50
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range producers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range each {
+				topic.Enqueue("payload")
+			}
+		}()
+	}
+	go func() {
+		defer close(done)
+		for range 20 {
+			if err := topic.Checkpoint(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	topic = restart(t, topic)
+	defer topic.Close()
+	if stats := topic.Stats(); stats.Pending != producers*each {
+		t.Fatalf("got %d of %d messages after restart", stats.Pending, producers*each)
+	}
+}
+
+func TestCheckpointCompactsOnlyCoveredSegments(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.SegmentMaxBytes = 256
+	topic := openTopic(t, "orders", config)
+	dir := topicDir("orders")
+
+	for i := range 20 {
+		topic.Enqueue(fmt.Sprintf("message %d", i+1))
+	}
+	for range 5 {
+		msg, _ := topic.Dequeue(DefaultGroup)
+		topic.Acknowledge(DefaultGroup, msg.Receipt)
+	}
+
+	checkpoint := func() (segments, snapshots []int64) {
+		t.Helper()
+		if err := topic.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		segments, _ = listSegments(dir)
+		snapshots, _ = listNumbered(dir, snapshotExt)
+		return segments, snapshots
+	}
+
+	segments, snapshots := checkpoint()
+	if len(snapshots) != 1 || snapshots[0] < 3 {
+		t.Fatalf("got snapshots %v, want one based after several segments", snapshots)
+	}
+	base := snapshots[0]
+	if len(segments) != 1 || segments[0] != base {
+		t.Fatalf("got segments %v, want only the active one (%d)", segments, base)
+	}
+
+	// History after the snapshot stays until the next checkpoint
+	for i := range 5 {
+		topic.Enqueue(fmt.Sprintf("message %d", i+21))
+	}
+	topic = restart(t, topic)
+	if segments, _ := listSegments(dir); segments[0] != base {
+		t.Fatalf("segments %v, want them to start at the snapshot base %d", segments, base)
+	}
+	expectIDs(t, topic, []int64{6, 7, 8})
+
+	// The next checkpoint supersedes the snapshot and its segments
+	segments, snapshots = checkpoint()
+	if len(snapshots) != 1 || snapshots[0] <= base || segments[0] != snapshots[0] {
+		t.Fatalf("got snapshots %v and segments %v after a second checkpoint", snapshots, segments)
+	}
+
+	topic = restart(t, topic)
+	defer topic.Close()
+	// 6, 7 and 8 are in flight, redelivered once their lease expires
+	expectIDs(t, topic, []int64{9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25})
+	topic.retryExpired(afterAckTimeout())
+	expectIDs(t, topic, []int64{6, 7, 8})
+}
@@ -7,7 +7,7 @@ import (
 )
 
 func BenchmarkQueueQps(b *testing.B) {
-	topic := NewTopic("test", TopicConfig{
+	topic := openTopic(b, "test", TopicConfig{
 		AckTimeout: 30 * time.Second,
 		MaxRetries: 3,
 	})
@@ -17,11 +17,11 @@ func BenchmarkQueueQps(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		topic.Enqueue(fmt.Sprintf("message %d", i))
 
-		msg, ok := topic.Dequeue()
+		msg, ok := topic.Dequeue(DefaultGroup)
 		if !ok {
 			b.Fatalf("queue empty %d", i)
 		}
 
-		topic.Acknowledge(msg.ID)
+		topic.Acknowledge(DefaultGroup, msg.Receipt)
 	}
 }
@@ -0,0 +1,53 @@
+package queue
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+)
+
+// A receipt identifies one delivery of a message: the message ID
+// followed by random bytes, base64url encoded. Acks, nacks and lease
+// extensions must present the receipt of the current delivery, so a
+// consumer whose lease ran out can't settle the redelivery, and a
+// client can't settle a message it was never handed.
+const receiptNonceSize = 16
+
+func newReceipt(id int64) string {
+	raw := make([]byte, 8+receiptNonceSize)
+	binary.BigEndian.PutUint64(raw, uint64(id))
+	rand.Read(raw[8:])
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// Message ID a receipt was issued for
+func receiptID(receipt string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(receipt)
+	if err != nil || len(raw) != 8+receiptNonceSize {
+		return 0, ErrInvalidReceipt
+	}
+	return int64(binary.BigEndian.Uint64(raw)), nil
+}
+
+// inFlight returns the group and in-flight message receipt was issued
+// for. Callers hold t.mu
+func (t *Topic) inFlight(group, receipt string) (*consumerGroup, Message, error) {
+	id, err := receiptID(receipt)
+	if err != nil {
+		return nil, Message{}, err
+	}
+
+	g, ok := t.groups[groupName(group)]
+	if !ok {
+		return nil, Message{}, ErrGroupNotFound
+	}
+
+	// Settled, or redelivered under a new receipt since
+	msg, ok := g.inFlight[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(msg.Receipt), []byte(receipt)) != 1 {
+		return nil, Message{}, ErrStaleReceipt
+	}
+
+	return g, msg, nil
+}
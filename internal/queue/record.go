@@ -0,0 +1,146 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Every WAL and snapshot entry is stored as a framed record:
+//
+//	length  uint32  bytes after the header (version + body)
+//	crc     uint32  CRC32C of version + body
+//	version uint8
+//	body    entry encoded by encodeEntry
+//
+// Version 2 added message headers to the body. Older versions are still
+// read, records are always written in the latest one.
+const (
+	recordHeaderSize = 8
+	recordVersion    = 2
+
+	maxRecordSize = 1 << 30
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errTornRecord   = errors.New("record cut short")
+	errRecordLength = errors.New("invalid record length")
+	errChecksum     = errors.New("checksum mismatch")
+)
+
+// writeRecord frames entry and writes it to writer. Records over
+// maxRecordSize are rejected, replay couldn't read them back.
+func writeRecord(writer io.Writer, entry LogEntry) error {
+	var buf bytes.Buffer
+	buf.Grow(recordHeaderSize + 64 + len(entry.Message.Payload))
+	buf.Write(make([]byte, recordHeaderSize)) // header, filled in below
+	buf.WriteByte(recordVersion)
+	if err := encodeEntry(&buf, entry); err != nil {
+		return err
+	}
+
+	record := buf.Bytes()
+	payload := record[recordHeaderSize:]
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("%w: %d bytes, at most %d", errRecordLength, len(payload), maxRecordSize)
+	}
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+
+	_, err := writer.Write(record)
+	return err
+}
+
+// recordReader reads framed records, tracking the offset of each one
+type recordReader struct {
+	r      io.Reader
+	offset int64 // start of the next record
+	size   int64 // total bytes available, <0 if unknown
+}
+
+// next returns the next entry and the offset it started at.
+// io.EOF means a clean end between records. errTornRecord and
+// errRecordLength mean the stream can't be followed any further;
+// any other error only concerns this record, the next call moves on.
+func (rr *recordReader) next() (LogEntry, int64, error) {
+	start := rr.offset
+
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(rr.r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return LogEntry{}, start, io.EOF
+		}
+		return LogEntry{}, start, errTornRecord
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length == 0 || length > maxRecordSize {
+		return LogEntry{}, start, errRecordLength
+	}
+	if rr.size >= 0 && start+recordHeaderSize+int64(length) > rr.size {
+		return LogEntry{}, start, errTornRecord
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(rr.r, payload); err != nil {
+		return LogEntry{}, start, errTornRecord
+	}
+	rr.offset = start + recordHeaderSize + int64(length)
+
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return LogEntry{}, start, errChecksum
+	}
+
+	version := payload[0]
+	if version == 0 || version > recordVersion {
+		return LogEntry{}, start, fmt.Errorf("unsupported record version %d", version)
+	}
+
+	entry, err := decodeEntry(bytes.NewReader(payload[1:]), version)
+	if err != nil {
+		return LogEntry{}, start, fmt.Errorf("malformed record body: %w", err)
+	}
+
+	return entry, start, nil
+}
+
+// findRecord returns the offset of the first valid record of file at or
+// after from, -1 if there is none
+func findRecord(file io.ReaderAt, from, size int64) (int64, error) {
+	if from >= size {
+		return -1, nil
+	}
+
+	data := make([]byte, size-from)
+	if _, err := file.ReadAt(data, from); err != nil && !errors.Is(err, io.EOF) {
+		return -1, err
+	}
+
+	for i := range data {
+		// Reject on the header alone before paying for the checksum
+		if len(data)-i < recordHeaderSize {
+			break
+		}
+		length := binary.LittleEndian.Uint32(data[i:])
+		if length == 0 || length > maxRecordSize || int64(length) > int64(len(data)-i-recordHeaderSize) {
+			continue
+		}
+
+		rr := recordReader{r: bytes.NewReader(data[i:]), size: int64(len(data) - i)}
+		if _, _, err := rr.next(); err == nil {
+			return from + int64(i), nil
+		}
+	}
+
+	return -1, nil
+}
+
+// Whether the reader lost track of record boundaries
+func fatalRecordErr(err error) bool {
+	return errors.Is(err, errTornRecord) || errors.Is(err, errRecordLength)
+}
@@ -1,35 +1,160 @@
 package queue
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
 	"os"
-	"strings"
+	"slices"
 	"sync"
+	"sync/atomic"
 )
 
 type TopicRegistry struct {
 	topics map[string]*Topic
 	mu     sync.RWMutex
 	config TopicConfig
+
+	// Topics being deleted, each channel is closed once its files are
+	// gone. A topic can't be created again before then, or it would
+	// lose its files to the deletion.
+	deleting map[string]chan struct{}
+
+	recovered atomic.Bool // LoadTopicFromDisk is done
 }
 
 // Creates a new empty registry
 func  NewTopicRegistry(config TopicConfig) *TopicRegistry {
 	return &TopicRegistry{
-		topics: make(map[string]*Topic),
-		config: config,
+		topics:   make(map[string]*Topic),
+		config:   config,
+		deleting: make(map[string]chan struct{}),
 	}
 }
 
-// creates a new topic
-func (r *TopicRegistry) CreateTopic(name string) {
+// lockToCreate locks r.mu once name isn't being deleted anymore
+func (r *TopicRegistry) lockToCreate(name string) {
 	r.mu.Lock()
+	for {
+		done, ok := r.deleting[name]
+		if !ok {
+			return
+		}
+		r.mu.Unlock()
+		<-done
+		r.mu.Lock()
+	}
+}
+
+// creates a new topic, unless it exists already
+func (r *TopicRegistry) CreateTopic(name string) error {
+	if err := validateTopicName(name); err != nil {
+		return err
+	}
+
+	r.lockToCreate(name)
 	defer r.mu.Unlock()
 
-	if _, exists := r.topics[name]; !exists {
-		r.topics[name] = NewTopic(name, r.config)
+	if _, exists := r.topics[name]; exists {
+		return nil
+	}
+
+	topic, err := r.newTopic(name, r.storedConfig(name, r.config))
+	if err != nil {
+		return err
+	}
+	r.topics[name] = topic
+	log.Println("Topic created:", name)
+	return nil
+}
+
+// PutTopic creates a topic with its own config, or applies config to it
+// if it exists (see Topic.UpdateConfig). Either way the config is kept
+// next to the topic's WAL and restored on recovery.
+// Reports whether the topic was created.
+func (r *TopicRegistry) PutTopic(name string, config TopicConfig) (bool, error) {
+	if err := validateTopicName(name); err != nil {
+		return false, err
+	}
+	if err := config.Validate(); err != nil {
+		return false, err
+	}
+
+	topic, exists, err := func() (*Topic, bool, error) {
+		r.lockToCreate(name)
+		defer r.mu.Unlock()
+
+		if topic, exists := r.topics[name]; exists {
+			return topic, true, nil
+		}
+		topic, err := r.newTopic(name, config)
+		if err != nil {
+			return nil, false, err
+		}
+		r.topics[name] = topic
 		log.Println("Topic created:", name)
+		return topic, false, nil
+	}()
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		return true, saveConfig(topic.wal.dir, config)
+	}
+	return false, topic.UpdateConfig(config)
+}
+
+// TopicConfig returns the config of topic name, or the config it would
+// be created with if it doesn't exist
+func (r *TopicRegistry) TopicConfig(name string) TopicConfig {
+	if topic := r.GetTopic(name); topic != nil {
+		return topic.Config()
 	}
+	return r.storedConfig(name, r.config)
+}
+
+// The config kept on disk for topic name, defaults if it has none
+func (r *TopicRegistry) storedConfig(name string, defaults TopicConfig) TopicConfig {
+	if validateTopicName(name) != nil {
+		return defaults
+	}
+
+	config, err := loadConfig(topicDir(name), defaults)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[Config ERROR] Topic: %s | %v, using defaults\n", name, err)
+	}
+	return config
+}
+
+// Builds a topic wired to this registry's dead-letter topics
+func (r *TopicRegistry) newTopic(name string, config TopicConfig) (*Topic, error) {
+	// Checked upfront, the dead-letter topic is only created once needed
+	dlqName := deadLetterTopicName(name, config)
+	if dlqName != "" {
+		if err := validateTopicName(dlqName); err != nil {
+			return nil, fmt.Errorf("dead-letter topic: %w", err)
+		}
+	}
+
+	t, err := NewTopic(name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if dlqName != "" {
+		t.SetDeadLetterSink(func(msg Message) error {
+			if err := r.CreateTopic(dlqName); err != nil {
+				return err
+			}
+			_, err := r.GetTopic(dlqName).republish("", msg)
+			return err
+		})
+	}
+
+	return t, nil
 }
 
 // Returns an existing topic
@@ -40,23 +165,213 @@ func (r *TopicRegistry) GetTopic(name string) *Topic {
 	return r.topics[name]
 }
 
-func (r *TopicRegistry) LoadTopicFromDisk(defaultConfig TopicConfig) {
-	files, err := os.ReadDir("data")
+// Returns the names of all topics, sorted
+func (r *TopicRegistry) Topics() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.topics))
+	for name := range r.topics {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// DeleteTopic removes a topic: its goroutines are stopped, its WAL is
+// closed and its files are deleted. Its dead-letter topic is kept.
+func (r *TopicRegistry) DeleteTopic(name string) error {
+	r.mu.Lock()
+	topic, exists := r.topics[name]
+	if !exists {
+		r.mu.Unlock()
+		return ErrTopicNotFound
+	}
+	delete(r.topics, name)
+	done := make(chan struct{})
+	r.deleting[name] = done
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.deleting, name)
+		r.mu.Unlock()
+		close(done)
+	}()
+
+	topic.Close()
+	forgetTopicMetrics(name)
+	if err := os.RemoveAll(topicDir(name)); err != nil {
+		return err
+	}
+
+	log.Println("Topic deleted:", name)
+	return nil
+}
+
+// Close closes every topic: their goroutines are stopped and their WALs
+// flushed and synced to disk
+func (r *TopicRegistry) Close() {
+	r.CloseWithin(context.Background())
+}
+
+// CloseWithin closes every topic like Close, but stops waiting once ctx
+// is done. It returns the names of the topics not closed by then, sorted,
+// they keep closing in the background.
+func (r *TopicRegistry) CloseWithin(ctx context.Context) []string {
+	r.mu.RLock()
+	topics := slices.Collect(maps.Values(r.topics))
+	r.mu.RUnlock()
+
+	var (
+		mu      sync.Mutex
+		pending = make(map[string]bool, len(topics))
+		wg      sync.WaitGroup
+	)
+	for _, topic := range topics {
+		pending[topic.Name] = true
+	}
+	for _, topic := range topics {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			topic.Close()
+
+			mu.Lock()
+			delete(pending, topic.Name)
+			mu.Unlock()
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Sorted(maps.Keys(pending))
+}
+
+// LoadTopicFromDisk recovers every topic found in DataDir. Topics that
+// can't be opened are skipped and reported in the error.
+func (r *TopicRegistry) LoadTopicFromDisk(defaultConfig TopicConfig) error {
+	defer r.recovered.Store(true)
+
+	// Logs written before segments existed become segment 1
+	migrateLegacyWALs()
+
+	files, err := os.ReadDir(DataDir)
 	if err != nil {
 		log.Println("No WALs found on disk.")
-		return
+		return nil
 	}
 
+	var errs []error
 	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".wal") {
-			topicName := strings.TrimSuffix(file.Name(), ".wal")
+		if !file.IsDir() {
+			continue
+		}
+
+		// Only directories holding WAL segments are topics
+		topicName := file.Name()
+		if ids, err := listSegments(topicDir(topicName)); err != nil || len(ids) == 0 {
+			continue
+		}
+
+		if err := r.recoverTopic(topicName, defaultConfig); err != nil {
+			errs = append(errs, fmt.Errorf("topic '%s': %w", topicName, err))
+		}
+	}
 
-			r.mu.Lock()
-			if _, exists := r.topics[topicName]; !exists {
-				r.topics[topicName] = NewTopic(topicName, defaultConfig)
-				log.Printf("[Recovery] Topic '%s' loaded from WAL.\n", topicName)
+	return errors.Join(errs...)
+}
+
+// recoverTopic opens topic name from its files, unless it's open already
+func (r *TopicRegistry) recoverTopic(name string, defaultConfig TopicConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.topics[name]; exists {
+		return nil
+	}
+
+	topic, err := r.newTopic(name, r.storedConfig(name, defaultConfig))
+	if err != nil {
+		return err
+	}
+	r.topics[name] = topic
+	log.Printf("[Recovery] Topic '%s' loaded from WAL.\n", name)
+	return nil
+}
+
+// Returns the dead-letter topic of name, nil if nothing was dead-lettered yet
+func (r *TopicRegistry) GetDeadLetterTopic(name string) *Topic {
+	topic := r.GetTopic(name)
+	if topic == nil {
+		return nil
+	}
+
+	dlqName := deadLetterTopicName(name, topic.Config())
+	if dlqName == "" {
+		return nil
+	}
+	return r.GetTopic(dlqName)
+}
+
+// Redrive moves every pending message of name's dead-letter topic back
+// to the topic it originally came from, with its retries reset.
+// Returns how many messages were moved.
+func (r *TopicRegistry) Redrive(name string) (int, error) {
+	dlq := r.GetDeadLetterTopic(name)
+	if dlq == nil {
+		return 0, ErrTopicNotFound
+	}
+
+	// Publish to the origin before removing from the DLQ, a crash in
+	// between leaves a duplicate rather than a lost message
+	var moved []int64
+	for _, msg := range dlq.Pending(0) {
+		origin, group := name, ""
+		if dl := msg.DeadLetter; dl != nil {
+			if dl.Topic != "" {
+				origin = dl.Topic
 			}
-			r.mu.Unlock()
+			group = dl.Group // only the group that gave up gets it back
 		}
+
+		if err := r.CreateTopic(origin); err != nil {
+			dlq.Remove(moved)
+			return len(moved), err
+		}
+		_, err := r.GetTopic(origin).republish(group, Message{
+			Payload:      msg.Payload,
+			Headers:      msg.Headers,
+			Priority:     msg.Priority,
+			MessageGroup: msg.MessageGroup,
+			DedupKey:     msg.DedupKey,
+		})
+		if errors.Is(err, ErrGroupNotFound) {
+			log.Printf("[DLQ] Topic: %s | Msg ID %d kept, group '%s' no longer exists\n", name, msg.ID, group)
+			continue
+		}
+		if err != nil {
+			dlq.Remove(moved)
+			return len(moved), err
+		}
+		moved = append(moved, msg.ID)
 	}
+
+	dlq.Remove(moved)
+	log.Printf("[DLQ] Topic: %s | redrove %d messages\n", name, len(moved))
+
+	return len(moved), nil
 }
@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryKind selects how long a message whose ack timed out waits before
+// it is delivered again
+type RetryKind string
+
+const (
+	// Every retry waits Delay (default, 0 = right away)
+	RetryFixed RetryKind = "fixed"
+	// Retry n waits Delay * 2^(n-1), capped at MaxDelay, with Jitter
+	RetryExponential RetryKind = "exponential"
+	// Retry n waits Schedule[n-1], the last entry repeating
+	RetrySchedule RetryKind = "schedule"
+)
+
+// RetryPolicy is the backoff applied to timed-out deliveries
+type RetryPolicy struct {
+	Kind     RetryKind
+	Delay    time.Duration
+	MaxDelay time.Duration   // exponential only, 0 = no cap
+	Jitter   float64         // exponential only, fraction of the delay randomized (0-1)
+	Schedule []time.Duration // schedule only
+}
+
+// Backoff returns how long retry number attempt (1 = first retry) waits
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	switch p.Kind {
+	case RetryExponential:
+		delay := p.Delay
+		for i := 1; i < attempt && delay < math.MaxInt64/2 && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+			delay *= 2
+		}
+		if p.MaxDelay > 0 {
+			delay = min(delay, p.MaxDelay)
+		}
+
+		if jitter := min(max(p.Jitter, 0), 1); jitter > 0 && delay > 0 {
+			spread := time.Duration(jitter * float64(delay))
+			delay = delay - spread + rand.N(spread+1)
+		}
+		return delay
+	case RetrySchedule:
+		if len(p.Schedule) == 0 {
+			return 0
+		}
+		return p.Schedule[min(max(attempt, 1), len(p.Schedule))-1]
+	default:
+		return p.Delay
+	}
+}
@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	segmentExt = ".seg"
+
+	DefaultSegmentMaxBytes = 64 << 20 // 64MB
+)
+
+// DataDir holds a directory per topic. Set it before creating topics
+var DataDir = "data"
+
+// Directory holding every segment of a topic
+func topicDir(topicName string) string {
+	return filepath.Join(DataDir, topicName)
+}
+
+// Segment files are named by their zero-padded sequence number so
+// that lexical order matches replay order.
+// eg: data/orders/00000000000000000001.seg
+func segmentPath(dir string, id int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// Returns the ids of all segments in dir in ascending order
+func listSegments(dir string) ([]int64, error) {
+	return listNumbered(dir, segmentExt)
+}
+
+// Returns the ids of all files named <id><ext> in dir in ascending order
+func listNumbered(dir string, ext string) ([]int64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ext) {
+			continue
+		}
+
+		id, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), ext), 10, 64)
+		if err != nil {
+			continue // not one of ours
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// segmentPolicy decides when the active segment should be rolled
+type segmentPolicy struct {
+	maxBytes int64
+	maxAge   time.Duration
+}
+
+func newSegmentPolicy(config TopicConfig) segmentPolicy {
+	p := segmentPolicy{
+		maxBytes: config.SegmentMaxBytes,
+		maxAge:   config.SegmentMaxAge,
+	}
+	if p.maxBytes <= 0 {
+		p.maxBytes = DefaultSegmentMaxBytes
+	}
+	return p
+}
+
+func (p segmentPolicy) full(size int64) bool {
+	return size >= p.maxBytes
+}
+
+// An empty segment is never rolled for age
+func (p segmentPolicy) expired(size int64, opened time.Time) bool {
+	return p.maxAge > 0 && size > 0 && time.Since(opened) >= p.maxAge
+}
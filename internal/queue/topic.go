@@ -1,233 +1,655 @@
 package queue
 
 import (
-	"bufio"
-	"encoding/binary"
-	"fmt"
+	"cmp"
+	"container/heap"
+	"context"
 	"log"
-	"os"
+	"maps"
+	"slices"
 	"sync"
 	"time"
-
-	"github.com/suman7383/go-queue/internal/ringbuffer"
 )
 
 // Topic represents a named message queue
 type Topic struct {
-	Name     string
-	messages Queue[Message]
-	inFlight map[int64]Message // delivered but not yet acked
-	nextID   int64
-	mu       sync.Mutex
-	config   TopicConfig
-	wal      *WAL
+	Name   string
+	groups map[string]*consumerGroup
+	nextID int64
+	mu     sync.Mutex
+	config TopicConfig
+	wal    *WAL
+
+	deadLetterSink func(Message) error // nil = drop
+
+	rescheduleCh   chan struct{} // wakes the scheduler when a message is delayed
+	leaseDeadlines leaseQueue    // in-flight deadlines for the retry sweep
+
+	expired int64 // messages expired since the topic was loaded
+
+	dedup *dedupIndex // producer deduplication keys
+
+	// Group commit: held for reading by publishes from their WAL append
+	// until their messages are queued, so checkpoints don't miss them
+	publishMu sync.RWMutex
+	// Closed once the latest group-commit publish is queued, publishes
+	// are queued in the order they were appended
+	lastPublish chan struct{}
+
+	checkpointMu sync.Mutex
+	lastSnapshot int64 // base segment of the newest snapshot
+
+	closed  bool
+	closeCh chan struct{}  // stops the background goroutines
+	doneCh  chan struct{}  // closed once the WAL is closed
+	wg      sync.WaitGroup // background goroutines
+
+	replayErr error // WAL replay failed at startup, state may be incomplete
 }
 
 // Create new topic queue
-func NewTopic(name string, config TopicConfig) *Topic {
-	wal, err := NewWAL(name)
+func NewTopic(name string, config TopicConfig) (*Topic, error) {
+	wal, err := NewWAL(name, config)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	t := &Topic{
-		Name:     name,
-		nextID:   1,
-		messages: ringbuffer.NewRingBuffer[Message](10000),
-		inFlight: make(map[int64]Message),
-		config:   config,
-		wal:      wal,
+		Name:   name,
+		nextID: 1,
+		groups: make(map[string]*consumerGroup),
+		config: config,
+		wal:    wal,
+
+		rescheduleCh: make(chan struct{}, 1),
+		dedup:        newDedupIndex(config),
+		closeCh:      make(chan struct{}),
+		doneCh:       make(chan struct{}),
 	}
 
 	// Replay WAL at startup
 	t.replayWAL()
 
-	// Retry goroutine
-	go func() {
-		for {
-			time.Sleep(2 * time.Second) // Check periodically
+	// Delayed delivery and retry goroutine
+	t.wg.Add(1)
+	go t.runScheduler()
 
-			t.mu.Lock()
-			now := time.Now()
-			for id, msg := range t.inFlight {
-				if !msg.Acked && now.Sub(msg.Timestamp) > t.config.AckTimeout {
-					if msg.Retries < t.config.MaxRetries {
-						// max retry not reached
-						msg.Retries++
-						log.Printf("[Retry] Topic: %s | Msg ID %d | Retry #%d\n", t.Name, msg.ID, msg.Retries)
-						t.messages.Enqueue(msg) // Requeue
-
-					} else {
-						// max retry reached -> discard the message
-						log.Printf("[DROP]: Msg ID %d exceeded max retries (%d). Discarded.\n", msg.ID, t.config.MaxRetries)
-						// TODO: move to DLQ here later
-					}
-					delete(t.inFlight, id)
+	// Checkpoint goroutine
+	if interval := checkpointInterval(config); interval > 0 {
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+				case <-t.closeCh:
+					return
+				}
+
+				if err := t.Checkpoint(); err != nil && err != ErrTopicClosed {
+					log.Printf("[Checkpoint ERROR] Topic: %s | %v\n", t.Name, err)
 				}
 			}
-			t.mu.Unlock()
-		}
-	}()
+		}()
+	}
+
+	return t, nil
+}
+
+// Close stops the topic's background goroutines and closes its WAL.
+// Publishing afterwards fails with ErrTopicClosed, other changes are
+// no longer persisted. Calls after the first wait for it to finish.
+func (t *Topic) Close() {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		<-t.doneCh
+		return
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	close(t.closeCh)
+	t.wg.Wait()
+	t.wal.Close()
+	close(t.doneCh)
+}
 
-	return t
+func checkpointInterval(config TopicConfig) time.Duration {
+	if config.CheckpointInterval == 0 {
+		return DefaultCheckpointInterval
+	}
+	return config.CheckpointInterval
 }
 
 // Enqueue adds a message to the topic
 func (t *Topic) Enqueue(payload string) (int64, error) {
+	return t.publish(Message{Payload: []byte(payload)})
+}
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	msg := Message{
-		ID:      t.nextID,
-		Payload: payload,
+// EnqueueBatch adds all payloads to the topic atomically: they get
+// consecutive IDs and are written to the WAL as one group.
+func (t *Topic) EnqueueBatch(payloads []string) ([]int64, error) {
+	msgs := make([]Message, len(payloads))
+	for i, payload := range payloads {
+		msgs[i] = Message{Payload: []byte(payload)}
 	}
 
-	// Append to WAL
-	t.wal.AppendEvent("enqueue", msg)
+	return t.publishBatch("", msgs, true)
+}
 
-	t.nextID++
-	t.messages.Enqueue(msg)
-	// t.messages.enqueue(msg)
+// EnqueueMessages is EnqueueBatch for messages carrying more than a
+// payload. Messages with a DeliverAt in the future are held back until
+// then. IDs are assigned by the topic.
+func (t *Topic) EnqueueMessages(msgs []Message) ([]int64, error) {
+	return t.publishBatch("", msgs, true)
+}
 
-	return msg.ID, nil
+// publish assigns msg the next ID and adds it to every consumer group.
+// Returns once the message is as durable as the topic's config asks.
+func (t *Topic) publish(msg Message) (int64, error) {
+	return t.publishTo("", msg)
 }
 
-// Dequeue returns the next message if exists(pull)
-func (t *Topic) Dequeue() (Message, bool) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+// publishTo is publish for a single group, or for every group if group
+// is "". A group that doesn't exist is not created.
+func (t *Topic) publishTo(group string, msg Message) (int64, error) {
+	ids, err := t.publishBatch(group, []Message{msg}, true)
+	if len(ids) == 0 {
+		return 0, err
+	}
+	return ids[0], err
+}
+
+// republish is publishTo for a message moved from another topic (a dead
+// letter, a redrive). It keeps its dedup key but is never deduplicated,
+// that already happened when it was first published.
+func (t *Topic) republish(group string, msg Message) (int64, error) {
+	ids, err := t.publishBatch(group, []Message{msg}, false)
+	if len(ids) == 0 {
+		return 0, err
+	}
+	return ids[0], err
+}
 
-	// if len(t.messages) == 0 {
-	// 	return Message{}, false
-	// }
+// publishBatch is publishTo for several messages under one lock and
+// one WAL group. With dedup unset, dedup keys are remembered but not
+// looked up.
+func (t *Topic) publishBatch(group string, msgs []Message, dedup bool) ([]int64, error) {
+	if len(msgs) == 0 {
+		return nil, nil
+	}
 
-	msg, ok := t.messages.Dequeue()
+	t.publishMu.RLock()
+	defer t.publishMu.RUnlock()
 
-	if !ok {
-		return msg, ok
+	t.mu.Lock()
+
+	// A duplicate of a publish still being synced waits for it: the
+	// producer retried as it wasn't answered yet, and if the sync fails
+	// the duplicate is published in its place
+	for dedup {
+		commit := t.committingDuplicate(msgs)
+		if commit == nil {
+			break
+		}
+		t.mu.Unlock()
+		commit.Wait()
+		t.mu.Lock()
 	}
 
-	t.wal.AppendEvent("deliver", msg)
+	if t.closed {
+		t.mu.Unlock()
+		return nil, ErrTopicClosed
+	}
+	if group != "" && t.groups[group] == nil {
+		t.mu.Unlock()
+		return nil, ErrGroupNotFound
+	}
 
-	// t.messages = t.messages[1:]
+	now := time.Now()
+	ids := make([]int64, len(msgs))
+	entries := make([]LogEntry, 0, len(msgs))
+	for i := range msgs {
+		// A key seen before gets the original ID and isn't published again
+		if key := msgs[i].DedupKey; key != "" && dedup {
+			if id, _, ok := t.dedup.lookup(key, now); ok {
+				ids[i] = id
+				continue
+			}
+		}
 
-	msg.Timestamp = time.Now()
-	msg.Acked = false
-	t.inFlight[msg.ID] = msg
+		msgs[i].ID = t.nextID
+		msgs[i].EnqueuedAt = now
+		t.nextID++
 
-	return msg, true
-}
+		if msgs[i].ExpiresAt.IsZero() && t.config.MessageTTL > 0 {
+			msgs[i].ExpiresAt = now.Add(t.config.MessageTTL)
+		}
+		if key := msgs[i].DedupKey; key != "" {
+			t.dedup.add(key, msgs[i].ID, now)
+		}
+
+		ids[i] = msgs[i].ID
+		entry := LogEntry{Type: "enqueue", Group: group, Message: msgs[i]}
+		if msgs[i].DeliverAt.After(now) {
+			entry.DueAt = msgs[i].DeliverAt
+		}
+		entries = append(entries, entry)
+	}
+
+	// Append to WAL
+	var commit *Commit
+	if len(entries) > 0 {
+		commit = t.wal.AppendBatch(entries)
+	}
+
+	if commit == nil {
+		t.queueEntries(group, entries)
+		t.mu.Unlock()
+		return ids, nil
+	}
+
+	// Group commit: messages are queued only once durable, a producer
+	// told its publish failed must not see them delivered
+	for _, entry := range entries {
+		t.dedup.committing(entry.Message.DedupKey, entry.Message.ID, commit)
+	}
+	prev, done := t.lastPublish, make(chan struct{})
+	t.lastPublish = done
+	t.mu.Unlock()
+	defer close(done)
+
+	// Wait outside the lock so concurrent producers share one fsync
+	err := commit.Wait()
+	if prev != nil {
+		<-prev
+	}
 
-func (t *Topic) Acknowledge(id int64) bool {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	msg, ok := t.inFlight[id]
-	if !ok {
-		return false
+	if err != nil {
+		// A retry must publish again, not get the IDs of lost messages
+		for _, entry := range entries {
+			t.dedup.forget(entry.Message.DedupKey, entry.Message.ID)
+		}
+		return ids, err
 	}
-	msg.Acked = true
+	for _, entry := range entries {
+		t.dedup.synced(entry.Message.DedupKey, commit)
+	}
+	t.queueEntries(group, entries)
 
-	// Append to WAL
-	t.wal.AppendEvent("ack", msg)
+	return ids, nil
+}
 
-	delete(t.inFlight, id)
+// committingDuplicate returns the commit of a publish that one of msgs
+// duplicates if it's still being synced, nil if there is none.
+// Callers hold t.mu
+func (t *Topic) committingDuplicate(msgs []Message) *Commit {
+	now := time.Now()
+	for _, msg := range msgs {
+		if msg.DedupKey == "" {
+			continue
+		}
+		if _, commit, _ := t.dedup.lookup(msg.DedupKey, now); commit != nil {
+			return commit
+		}
+	}
+	return nil
+}
 
-	return true
+// queueEntries makes published entries deliverable in group, or in
+// every group subscribed before they were published if group is "".
+// Callers hold t.mu
+func (t *Topic) queueEntries(group string, entries []LogEntry) {
+	for name, g := range t.groups {
+		if group != "" && group != name {
+			continue
+		}
+		for _, entry := range entries {
+			if group == "" && entry.Message.ID < g.cursor {
+				continue // subscribed while the entry was being committed
+			}
+			if !entry.DueAt.IsZero() {
+				t.delay(g, entry.Message, entry.DueAt)
+				continue
+			}
+			g.push(entry.Message)
+		}
+	}
+	enqueuedTotal.Add(float64(len(entries)), t.Name)
 }
 
-func (t *Topic) replayWAL() {
-	path := fmt.Sprintf("data/%s.wal", t.Name)
-	file, err := os.Open(path)
+// Dequeue returns the group's next message if exists(pull).
+// Returns false if there is none or the group doesn't exist.
+func (t *Topic) Dequeue(group string) (Message, bool) {
+	msgs, _ := t.DequeueBatch(group, 1)
+	if len(msgs) == 0 {
+		return Message{}, false
+	}
+	return msgs[0], true
+}
+
+// DequeueBatch returns up to max of the group's next messages.
+// Expired messages are skipped. Groups other than DefaultGroup must be
+// subscribed first, or ErrGroupNotFound is returned: a mistyped group
+// would otherwise keep every message published from then on.
+func (t *Topic) DequeueBatch(group string, max int) ([]Message, error) {
+	t.mu.Lock()
+	g, err := t.consumeGroup(group)
 	if err != nil {
-		log.Println("No WAL found for topic:", t.Name)
-		return
+		t.mu.Unlock()
+		return nil, err
 	}
-	defer file.Close()
+	msgs, expired := t.dequeue(g, max)
+	t.mu.Unlock()
 
-	reader := bufio.NewReader(file)
+	t.expire(g.name, expired)
+	return msgs, nil
+}
 
-	type msgState struct {
-		message   Message
-		enqueued  bool
-		delivered bool
-		acked     bool
+// DequeueWait is Dequeue that blocks until a message arrives or ctx is
+// done (long polling). Returns false if ctx ends first or the group
+// doesn't exist or is unsubscribed meanwhile.
+func (t *Topic) DequeueWait(ctx context.Context, group string) (Message, bool) {
+	msgs, _ := t.DequeueBatchWait(ctx, group, 1)
+	if len(msgs) == 0 {
+		return Message{}, false
 	}
+	return msgs[0], true
+}
 
-	msgMap := make(map[int64]*msgState)
+// DequeueBatchWait is DequeueBatch that blocks until at least one
+// message is available, see DequeueWait. A group unsubscribed while
+// waiting gets ErrGroupNotFound.
+func (t *Topic) DequeueBatchWait(ctx context.Context, group string, max int) ([]Message, error) {
+	t.mu.Lock()
+	g, err := t.consumeGroup(group)
+	if err != nil {
+		t.mu.Unlock()
+		return nil, err
+	}
+
+	var expired []Message
+	defer func() { t.expire(g.name, expired) }() // runs once t.mu is released
 
 	for {
-		// --- Decode Type (uint16 length + string) ---
-		var typeLen uint16
-		if err := binary.Read(reader, binary.LittleEndian, &typeLen); err != nil {
-			break // reached EOF
+		if t.groups[g.name] != g {
+			t.mu.Unlock()
+			return nil, ErrGroupNotFound // unsubscribed while waiting
 		}
 
-		typeBytes := make([]byte, typeLen)
-		if _, err := reader.Read(typeBytes); err != nil {
-			break
+		msgs, skipped := t.dequeue(g, max)
+		expired = append(expired, skipped...)
+		if len(msgs) > 0 {
+			t.mu.Unlock()
+			return msgs, nil
 		}
-		entryType := string(typeBytes)
 
-		// --- Decode Message ID ---
-		var id int64
-		if err := binary.Read(reader, binary.LittleEndian, &id); err != nil {
-			break
-		}
+		wake := make(chan struct{}, 1)
+		g.waiters = append(g.waiters, wake)
+		t.mu.Unlock()
 
-		// --- Decode Payload (uint32 length + string) ---
-		var payloadLen uint32
-		if err := binary.Read(reader, binary.LittleEndian, &payloadLen); err != nil {
-			break
+		select {
+		case <-wake:
+			t.mu.Lock()
+		case <-ctx.Done():
+			t.mu.Lock()
+			if !g.cancelWait(wake) {
+				// Woken for a message we won't take, pass it on
+				g.wake(1)
+			}
+			t.mu.Unlock()
+			return nil, nil
 		}
+	}
+}
 
-		payloadBytes := make([]byte, payloadLen)
-		if _, err := reader.Read(payloadBytes); err != nil {
+// Delivers up to max messages of g, also returning the expired ones
+// taken off the queue on the way. Callers hold t.mu
+func (t *Topic) dequeue(g *consumerGroup, max int) (msgs, expiredMsgs []Message) {
+	now := time.Now()
+	for len(msgs) < max {
+		msg, ok := g.messages.Dequeue()
+		if !ok {
 			break
 		}
 
-		// --- Decode Timestamp ---
-		var ts int64
-		if err := binary.Read(reader, binary.LittleEndian, &ts); err != nil {
-			break
+		if expired(msg, now) {
+			expiredMsgs = append(expiredMsgs, msg)
+			g.release(msg)
+			continue
+		}
+		if !g.take(msg) {
+			continue // an earlier message of its group is in flight
 		}
 
-		// --- Decode Acked ---
-		var acked uint8
-		if err := binary.Read(reader, binary.LittleEndian, &acked); err != nil {
-			break
+		msg.Timestamp = now
+		msg.Acked = false
+		msg.Receipt = newReceipt(msg.ID)
+		g.inFlight[msg.ID] = msg
+		delete(g.leases, msg.ID)
+		t.watchLease(g, msg)
+		msgs = append(msgs, msg)
+
+		deliveredTotal.Inc(t.Name)
+		if !msg.EnqueuedAt.IsZero() {
+			messageAgeSeconds.Observe(now.Sub(msg.EnqueuedAt).Seconds(), t.Name)
 		}
+	}
 
-		// --- Decode Retries ---
-		var retries int32
-		if err := binary.Read(reader, binary.LittleEndian, &retries); err != nil {
-			break
+	if len(msgs) > 0 {
+		entries := make([]LogEntry, len(msgs))
+		for i, msg := range msgs {
+			entries[i] = LogEntry{Type: "deliver", Group: g.name, Message: msg}
 		}
+		t.wal.AppendBatch(entries)
+	}
+
+	return msgs, expiredMsgs
+}
+
+// Acknowledge settles the delivery receipt was issued for
+func (t *Topic) Acknowledge(group, receipt string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g, msg, err := t.inFlight(group, receipt)
+	if err != nil {
+		return err
+	}
+	msg.Acked = true
+
+	// Append to WAL
+	t.wal.Append(LogEntry{Type: "ack", Group: g.name, Message: msg})
+
+	g.settle(msg.ID)
+	g.release(msg)
+	ackedTotal.Inc(t.Name)
+
+	return nil
+}
+
+// Nack hands the message of the delivery receipt was issued for back to
+// its group, right away or once delay has passed. It counts as a retry,
+// so a message out of retries is dead-lettered instead.
+func (t *Topic) Nack(group, receipt string, delay time.Duration) error {
+	t.mu.Lock()
+
+	g, msg, err := t.inFlight(group, receipt)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	g.settle(msg.ID)
+
+	if msg.Retries >= t.config.MaxRetries {
+		t.mu.Unlock()
+		t.deadLetter(g.name, []Message{msg}, "max retries exceeded")
+		return nil
+	}
+
+	msg.Retries++
+	msg.Receipt = ""
+	entry := LogEntry{Type: "nack", Group: g.name, Message: msg}
+	if delay > 0 {
+		entry.DueAt = time.Now().Add(delay)
+	}
+
+	// Append to WAL
+	t.wal.Append(entry)
+
+	if delay > 0 {
+		t.delay(g, msg, entry.DueAt)
+	} else {
+		g.push(msg)
+	}
+	log.Printf("[Nack] Topic: %s | Group: %s | Msg ID %d | Retry #%d in %v\n", t.Name, g.name, msg.ID, msg.Retries, delay)
+	nackedTotal.Inc(t.Name)
+	t.mu.Unlock()
+
+	return nil
+}
 
-		// Build message
-		msg := Message{
-			ID:        int64(id),
-			Payload:   string(payloadBytes),
-			Timestamp: time.Unix(0, ts),
-			Acked:     acked == 1,
-			Retries:   int(retries),
+// retryExpired requeues in-flight messages whose ack timed out by now,
+// after the backoff of the topic's RetryPolicy, and dead-letters those
+// out of retries
+func (t *Topic) retryExpired(now time.Time) {
+	dead := make(map[string][]Message)
+
+	t.mu.Lock()
+	for g, msgs := range t.expiredLeases(now) {
+		for _, msg := range msgs {
+			g.settle(msg.ID)
+
+			if msg.Retries >= t.config.MaxRetries {
+				// max retry reached -> dead-letter the message
+				dead[g.name] = append(dead[g.name], msg)
+				continue
+			}
+
+			// max retry not reached
+			msg.Retries++
+			msg.Receipt = ""
+			entry := LogEntry{Type: "retry", Group: g.name, Message: msg}
+			backoff := t.config.Retry.Backoff(msg.Retries)
+			if backoff > 0 {
+				entry.DueAt = now.Add(backoff)
+			}
+
+			// Append to WAL
+			t.wal.Append(entry)
+
+			log.Printf("[Retry] Topic: %s | Group: %s | Msg ID %d | Retry #%d in %v\n", t.Name, g.name, msg.ID, msg.Retries, backoff)
+			retriedTotal.Inc(t.Name)
+			if backoff > 0 {
+				t.delay(g, msg, entry.DueAt)
+			} else {
+				g.push(msg) // Requeue
+			}
 		}
+	}
+	t.mu.Unlock()
+
+	for _, name := range slices.Sorted(maps.Keys(dead)) {
+		t.deadLetter(name, dead[name], "max retries exceeded")
+	}
+}
 
-		// Track state
-		state, exists := msgMap[msg.ID]
-		if !exists {
-			state = &msgState{}
-			msgMap[msg.ID] = state
+// Keys of m in ascending order
+func sortedIDs[V any](m map[int64]V) []int64 {
+	ids := make([]int64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (t *Topic) replayWAL() {
+	type pendingMsg struct {
+		message Message
+		seq     int       // log position where it (re)entered the pending queue
+		due     time.Time // held back until then, zero if deliverable
+	}
+
+	type groupState struct {
+		cursor   int64
+		pending  map[int64]pendingMsg
+		inFlight map[int64]Message
+		leases   map[int64]time.Time
+	}
+
+	newGroupState := func(cursor int64) *groupState {
+		return &groupState{
+			cursor:   cursor,
+			pending:  make(map[int64]pendingMsg),
+			inFlight: make(map[int64]Message),
+			leases:   make(map[int64]time.Time),
 		}
-		state.message = msg
+	}
+
+	// Logs written before groups existed only know the default group
+	groups := map[string]*groupState{DefaultGroup: newGroupState(1)}
+	seq := 0
+
+	apply := func(entry LogEntry) {
+		seq++
+		msg := entry.Message
 
-		switch entryType {
+		switch entry.Type {
+		case "subscribe":
+			if _, ok := groups[entry.Group]; !ok {
+				groups[entry.Group] = newGroupState(msg.ID)
+			}
+			return // msg.ID is a cursor, not a message
+		case "unsubscribe":
+			delete(groups, entry.Group)
+			return
+		case "dedup":
+			t.dedup.add(msg.DedupKey, msg.ID, msg.EnqueuedAt)
+			return
 		case "enqueue":
-			state.enqueued = true
-		case "deliver":
-			state.delivered = true
-		case "ack":
-			state.acked = true
+			if msg.DedupKey != "" {
+				t.dedup.add(msg.DedupKey, msg.ID, msg.EnqueuedAt)
+			}
+			// No group means every group subscribed at that point
+			for name, g := range groups {
+				if (entry.Group == "" && msg.ID >= g.cursor) || entry.Group == name {
+					g.pending[msg.ID] = pendingMsg{message: msg, seq: seq, due: entry.DueAt}
+				}
+			}
+		case "nack", "retry":
+			if g, ok := groups[entry.Group]; ok {
+				delete(g.inFlight, msg.ID)
+				delete(g.leases, msg.ID)
+				g.pending[msg.ID] = pendingMsg{message: msg, seq: seq, due: entry.DueAt}
+			}
+		case "extend":
+			if g, ok := groups[entry.Group]; ok {
+				if _, ok := g.inFlight[msg.ID]; ok {
+					g.leases[msg.ID] = entry.DueAt
+				}
+			}
+		default:
+			// "deliver" | "ack" | "dead" | "expire" | "purge"
+			if g, ok := groups[groupName(entry.Group)]; ok {
+				delete(g.pending, msg.ID)
+				delete(g.inFlight, msg.ID)
+				delete(g.leases, msg.ID)
+				if entry.Type == "deliver" {
+					g.inFlight[msg.ID] = msg
+					if !entry.DueAt.IsZero() {
+						g.leases[msg.ID] = entry.DueAt
+					}
+				}
+			}
 		}
 
 		// Ensure nextID is larger than any ID seen
@@ -236,16 +658,62 @@ func (t *Topic) replayWAL() {
 		}
 	}
 
-	// Rebuild topic state
-	for id, state := range msgMap {
-		if state.acked {
-			continue // skip fully ACKed messages
+	// Start from the latest snapshot and replay only the WAL after it
+	if snap, ok := loadLatestSnapshot(t.wal.dir); ok {
+		t.nextID = snap.nextID
+		t.lastSnapshot = snap.base
+		for _, entry := range snap.entries {
+			apply(entry)
+		}
+	}
+
+	corrupt, err := t.wal.Replay(t.lastSnapshot, apply)
+	for _, c := range corrupt {
+		log.Printf("[Recovery] Topic '%s': corrupt record in %s at offsets %d-%d skipped: %s\n", t.Name, c.Segment, c.Offset, c.End, c.Reason)
+	}
+	if err != nil {
+		log.Printf("[Recovery] Topic '%s': WAL replay failed: %v\n", t.Name, err)
+		t.replayErr = err
+	}
+
+	// Rebuild topic state. The maps are unordered, so pending messages
+	// are put back in the order they were queued
+	now := time.Now()
+	for name, state := range groups {
+		g := newConsumerGroup(name, state.cursor, t.config)
+
+		pending := slices.SortedFunc(maps.Values(state.pending), func(a, b pendingMsg) int {
+			return cmp.Compare(a.seq, b.seq)
+		})
+		var ready []Message
+		for _, p := range pending {
+			if p.due.After(now) {
+				heap.Push(&g.delayed, delayedMsg{msg: p.message, due: p.due})
+
+				// A message nacked with a delay (not one scheduled by its
+				// producer) keeps blocking its message group
+				if key := p.message.MessageGroup; key != "" && !p.due.Equal(p.message.DeliverAt) {
+					g.owners[key] = p.message.ID
+				}
+				continue
+			}
+			ready = append(ready, p.message)
+		}
+		orderMessageGroups(ready)
+		for _, msg := range ready {
+			g.messages.Enqueue(msg)
 		}
 
-		if state.delivered {
-			t.inFlight[id] = state.message
-		} else if state.enqueued {
-			t.messages.Enqueue(state.message)
+		maps.Copy(g.inFlight, state.inFlight)
+		maps.Copy(g.leases, state.leases)
+		for _, id := range sortedIDs(state.inFlight) {
+			msg := state.inFlight[id]
+			if msg.MessageGroup != "" {
+				g.owners[msg.MessageGroup] = msg.ID
+			}
 		}
+
+		t.groups[name] = g
 	}
+	t.watchAllLeases()
 }
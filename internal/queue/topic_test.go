@@ -0,0 +1,1267 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const restarts = 25
+
+var orderConfig = TopicConfig{
+	AckTimeout:         time.Hour,
+	MaxRetries:         3,
+	CheckpointInterval: -1, // tests checkpoint explicitly
+}
+
+// Opens a topic, failing the test if it can't
+func openTopic(tb testing.TB, name string, config TopicConfig) *Topic {
+	tb.Helper()
+
+	topic, err := NewTopic(name, config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return topic
+}
+
+// Closes topic's WAL and recovers a fresh topic from disk
+func restart(t *testing.T, topic *Topic) *Topic {
+	t.Helper()
+
+	topic.Close()
+	return openTopic(t, topic.Name, topic.config)
+}
+
+// Restarts topic n times, checkpointing after every other restart so
+// recovery alternates between a snapshot and the WAL alone
+func restartAlternating(t *testing.T, topic *Topic, n int) *Topic {
+	t.Helper()
+
+	for round := range n {
+		topic = restart(t, topic)
+		if round%2 == 0 {
+			if err := topic.Checkpoint(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return topic
+}
+
+// A time past the ack deadline of everything delivered so far
+func afterAckTimeout() time.Time {
+	return time.Now().Add(orderConfig.AckTimeout + time.Second)
+}
+
+// Dequeues n messages and checks their IDs follow want in order
+func expectIDs(t *testing.T, topic *Topic, want []int64) {
+	t.Helper()
+
+	for i, id := range want {
+		msg, ok := topic.Dequeue(DefaultGroup)
+		if !ok {
+			t.Fatalf("queue empty after %d of %d messages", i, len(want))
+		}
+		if msg.ID != id {
+			t.Fatalf("message %d: got ID %d, want %d", i, msg.ID, id)
+		}
+	}
+}
+
+func TestReplayKeepsPendingOrder(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+	for i := range 500 {
+		topic.Enqueue(fmt.Sprintf("message %d", i+1))
+	}
+
+	for range restarts {
+		topic = restart(t, topic)
+	}
+
+	want := make([]int64, 500)
+	for i := range want {
+		want[i] = int64(i + 1)
+	}
+	expectIDs(t, topic, want)
+}
+
+func TestReplayKeepsOrderWhileConsuming(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+
+	// Each round produces some, consumes and acks some, then restarts.
+	// What's left must always come out in ID order.
+	next := int64(1)
+	for round := range restarts {
+		for range 20 {
+			topic.Enqueue("payload")
+		}
+
+		for range 10 + round%5 {
+			msg, ok := topic.Dequeue(DefaultGroup)
+			if !ok {
+				t.Fatalf("round %d: queue empty", round)
+			}
+			if msg.ID != next {
+				t.Fatalf("round %d: got ID %d, want %d", round, msg.ID, next)
+			}
+			topic.Acknowledge(DefaultGroup, msg.Receipt)
+			next++
+		}
+
+		topic = restart(t, topic)
+	}
+}
+
+func TestReplayKeepsOrderAcrossCheckpoints(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+
+	var want []int64
+	for round := range restarts {
+		for range 10 {
+			id, _ := topic.Enqueue("payload")
+			want = append(want, id)
+		}
+
+		// Alternate between recovering from a snapshot and from the WAL
+		if round%2 == 0 {
+			if err := topic.Checkpoint(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		topic = restart(t, topic)
+	}
+
+	expectIDs(t, topic, want)
+}
+
+func TestReplayKeepsRetryOrder(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+	for range 10 {
+		topic.Enqueue("payload")
+	}
+
+	// 1..5 time out and go back behind 6..10
+	for range 5 {
+		topic.Dequeue(DefaultGroup)
+	}
+	topic.retryExpired(afterAckTimeout())
+
+	// The snapshot is the only record of the requeue
+	if err := topic.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	for range restarts {
+		topic = restart(t, topic)
+	}
+
+	expectIDs(t, topic, []int64{6, 7, 8, 9, 10, 1, 2, 3, 4, 5})
+}
+
+func TestRedeliveryOrderAfterRestart(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+	for range 50 {
+		topic.Enqueue("payload")
+	}
+
+	// Everything in flight when the topic goes down
+	for range 50 {
+		topic.Dequeue(DefaultGroup)
+	}
+
+	for attempt := range restarts {
+		topic = restart(t, topic)
+		if len(topic.groups[DefaultGroup].inFlight) != 50 {
+			t.Fatalf("restart %d: %d messages in flight, want 50", attempt, len(topic.groups[DefaultGroup].inFlight))
+		}
+	}
+
+	// Expired in-flight messages must be redelivered in ID order,
+	// not in map iteration order
+	topic.retryExpired(afterAckTimeout())
+
+	want := make([]int64, 50)
+	for i := range want {
+		want[i] = int64(i + 1)
+	}
+	expectIDs(t, topic, want)
+}
+
+func TestConsumingUnknownGroupDoesNotSubscribe(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+	defer topic.Close()
+	topic.Enqueue("payload")
+
+	if _, err := topic.DequeueBatch("biling", 1); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("got %v, want ErrGroupNotFound", err)
+	}
+	if _, err := topic.DequeueBatchWait(t.Context(), "biling", 1); !errors.Is(err, ErrGroupNotFound) {
+		t.Fatalf("got %v, want ErrGroupNotFound", err)
+	}
+	if groups := fmt.Sprint(topic.Groups()); groups != "[default]" {
+		t.Fatalf("got groups %s, want only the default one", groups)
+	}
+
+	// The default group comes back on demand
+	topic.Unsubscribe(DefaultGroup)
+	topic.Enqueue("payload")
+	if msgs, err := topic.DequeueBatch("", 1); err != nil || len(msgs) != 0 {
+		t.Fatalf("got %v, %v from a fresh default group", msgs, err)
+	}
+}
+
+func TestGroupsSurviveRestart(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+	topic.Enqueue("before billing subscribed")
+	topic.Subscribe("billing")
+	topic.Enqueue("payload")
+	topic.Enqueue("payload")
+
+	// billing consumes on its own, default still has everything
+	msg, _ := topic.Dequeue("billing")
+	topic.Acknowledge("billing", msg.Receipt)
+
+	topic = restartAlternating(t, topic, restarts)
+
+	if groups := fmt.Sprint(topic.Groups()); groups != "[billing default]" {
+		t.Fatalf("groups after restart: %s", groups)
+	}
+
+	expectIDs(t, topic, []int64{1, 2, 3})
+
+	msg, ok := topic.Dequeue("billing")
+	if !ok || msg.ID != 3 {
+		t.Fatalf("billing got %d (ok=%v), want 3", msg.ID, ok)
+	}
+	if _, ok := topic.Dequeue("billing"); ok {
+		t.Fatal("billing received a message from before it subscribed")
+	}
+}
+
+func TestBatchesPublishAtomically(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+
+	// Concurrent batches never interleave
+	const producers, size = 8, 25
+	batches := make([][]int64, producers)
+	var wg sync.WaitGroup
+	for p := range producers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payloads := make([]string, size)
+			for i := range payloads {
+				payloads[i] = fmt.Sprintf("producer %d message %d", p, i)
+			}
+			batches[p], _ = topic.EnqueueBatch(payloads)
+		}()
+	}
+	wg.Wait()
+
+	for _, ids := range batches {
+		for i := range ids {
+			if ids[i] != ids[0]+int64(i) {
+				t.Fatalf("batch got IDs %v, want consecutive ones", ids)
+			}
+		}
+	}
+
+	// Each batch is delivered whole and in order, also after a restart
+	topic = restart(t, topic)
+	defer topic.Close()
+	msgs, _ := topic.DequeueBatch(DefaultGroup, producers*size)
+	if len(msgs) != producers*size {
+		t.Fatalf("got %d of %d messages", len(msgs), producers*size)
+	}
+	producer := func(msg Message) string {
+		p, _, _ := strings.Cut(string(msg.Payload), " message")
+		return p
+	}
+	for i, msg := range msgs {
+		if msg.ID != int64(i+1) {
+			t.Fatalf("message %d has ID %d", i, msg.ID)
+		}
+		if i%size > 0 && producer(msg) != producer(msgs[i-1]) {
+			t.Fatalf("batches interleaved at message %d", i)
+		}
+	}
+}
+
+func TestDequeueWaitWakesOnEnqueue(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+
+	got := make(chan Message, 3)
+	for range 3 {
+		go func() {
+			msg, ok := topic.DequeueWait(t.Context(), DefaultGroup)
+			if ok {
+				got <- msg
+			}
+		}()
+	}
+
+	// Let the consumers block before producing
+	time.Sleep(20 * time.Millisecond)
+	topic.Enqueue("payload")
+	topic.Enqueue("payload")
+
+	seen := map[int64]bool{}
+	for range 2 {
+		select {
+		case msg := <-got:
+			seen[msg.ID] = true
+		case <-time.After(time.Second):
+			t.Fatal("waiting consumer was not woken")
+		}
+	}
+	if !seen[1] || !seen[2] {
+		t.Fatalf("consumers got %v, want IDs 1 and 2", seen)
+	}
+
+	// The third consumer is still waiting and gives up with its context
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if _, ok := topic.DequeueWait(ctx, DefaultGroup); ok {
+		t.Fatal("DequeueWait returned a message from an empty queue")
+	}
+}
+
+func TestNackRequeuesAndSurvivesRestart(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+	for range 3 {
+		topic.Enqueue("payload")
+	}
+
+	// 1 comes back right away, 2 only after an hour
+	first, _ := topic.Dequeue(DefaultGroup)
+	second, _ := topic.Dequeue(DefaultGroup)
+	if err := topic.Nack(DefaultGroup, first.Receipt, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := topic.Nack(DefaultGroup, second.Receipt, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := topic.Nack(DefaultGroup, first.Receipt, 0); err != ErrStaleReceipt {
+		t.Fatalf("second nack of the same delivery: got %v, want ErrStaleReceipt", err)
+	}
+
+	topic = restartAlternating(t, topic, restarts)
+
+	msg, _ := topic.Dequeue(DefaultGroup)
+	if msg.ID != 3 {
+		t.Fatalf("got ID %d, want 3", msg.ID)
+	}
+	msg, _ = topic.Dequeue(DefaultGroup)
+	if msg.ID != 1 || msg.Retries != 1 {
+		t.Fatalf("got ID %d with %d retries, want 1 with 1", msg.ID, msg.Retries)
+	}
+	if _, ok := topic.Dequeue(DefaultGroup); ok {
+		t.Fatal("delayed message delivered before it was due")
+	}
+
+	// Once due, the scheduler releases it
+	topic.releaseDue(time.Now().Add(2 * time.Hour))
+	msg, _ = topic.Dequeue(DefaultGroup)
+	if msg.ID != 2 || msg.Retries != 1 {
+		t.Fatalf("got ID %d with %d retries, want 2 with 1", msg.ID, msg.Retries)
+	}
+}
+
+func TestExtendLeaseSurvivesRestart(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.AckTimeout = time.Minute
+	config.MaxLease = time.Hour
+	topic := openTopic(t, "orders", config)
+	topic.Enqueue("payload")
+	msg, _ := topic.Dequeue(DefaultGroup)
+
+	deadline, err := topic.ExtendLease(DefaultGroup, msg.Receipt, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic = restartAlternating(t, topic, restarts)
+
+	g := topic.groups[DefaultGroup]
+	if got := g.deadline(g.inFlight[msg.ID], config.AckTimeout); !got.Equal(deadline) {
+		t.Fatalf("deadline after restart %v, want %v", got, deadline)
+	}
+
+	// Past AckTimeout but within the lease: not redelivered
+	topic.retryExpired(time.Now().Add(2 * time.Minute))
+	if _, ok := topic.Dequeue(DefaultGroup); ok {
+		t.Fatal("message redelivered within its extended lease")
+	}
+
+	// Extensions stop at MaxLease
+	deadline, err = topic.ExtendLease(DefaultGroup, msg.Receipt, 2*time.Hour)
+	if err != nil || !deadline.Equal(msg.Timestamp.Add(time.Hour)) {
+		t.Fatalf("got deadline %v (%v), want it capped at %v", deadline, err, msg.Timestamp.Add(time.Hour))
+	}
+	if _, err := topic.ExtendLease(DefaultGroup, msg.Receipt, time.Minute); err != ErrLeaseLimit {
+		t.Fatalf("got %v, want ErrLeaseLimit", err)
+	}
+}
+
+func TestStaleReceiptRejected(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+	topic.Enqueue("payload")
+
+	// The first consumer's lease runs out and the message is redelivered
+	slow, _ := topic.Dequeue(DefaultGroup)
+	topic.retryExpired(afterAckTimeout())
+	fast, _ := topic.Dequeue(DefaultGroup)
+	if fast.ID != slow.ID || fast.Receipt == slow.Receipt {
+		t.Fatalf("redelivery of %d got ID %d with the same receipt", slow.ID, fast.ID)
+	}
+
+	if err := topic.Acknowledge(DefaultGroup, slow.Receipt); err != ErrStaleReceipt {
+		t.Fatalf("ack with the old receipt: got %v, want ErrStaleReceipt", err)
+	}
+	if err := topic.Acknowledge(DefaultGroup, "not-a-receipt"); err != ErrInvalidReceipt {
+		t.Fatalf("ack with a made up receipt: got %v, want ErrInvalidReceipt", err)
+	}
+
+	// Receipts survive a restart
+	topic = restart(t, topic)
+	if err := topic.Acknowledge(DefaultGroup, fast.Receipt); err != nil {
+		t.Fatal(err)
+	}
+	if err := topic.Acknowledge(DefaultGroup, fast.Receipt); err != ErrStaleReceipt {
+		t.Fatalf("second ack: got %v, want ErrStaleReceipt", err)
+	}
+}
+
+func TestScheduledDeliverySurvivesRestart(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+	due := time.Now().Add(time.Hour)
+	topic.EnqueueMessages([]Message{
+		{Payload: []byte("later"), DeliverAt: due},
+		{Payload: []byte("now")},
+		{Payload: []byte("in the past"), DeliverAt: time.Now().Add(-time.Hour)},
+	})
+
+	topic = restartAlternating(t, topic, restarts)
+
+	expectIDs(t, topic, []int64{2, 3})
+	if _, ok := topic.Dequeue(DefaultGroup); ok {
+		t.Fatal("scheduled message delivered before it was due")
+	}
+
+	if next := topic.releaseDue(due.Add(-time.Second)); !next.Equal(due) {
+		t.Fatalf("restored due time %v, want %v", next, due)
+	}
+	topic.releaseDue(due)
+	expectIDs(t, topic, []int64{1})
+}
+
+func TestExpiredMessagesSkippedAndNotResurrected(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.MessageTTL = time.Hour
+	config.DeadLetterExpired = true
+	topic := openTopic(t, "orders", config)
+
+	var deadLettered []Message
+	topic.SetDeadLetterSink(func(msg Message) error {
+		deadLettered = append(deadLettered, msg)
+		return nil
+	})
+
+	past := time.Now().Add(-time.Second)
+	topic.EnqueueMessages([]Message{
+		{Payload: []byte("stale"), ExpiresAt: past},
+		{Payload: []byte("fresh")},
+		{Payload: []byte("stale"), ExpiresAt: past},
+	})
+
+	expectIDs(t, topic, []int64{2})
+	if _, ok := topic.Dequeue(DefaultGroup); ok {
+		t.Fatal("expired message delivered")
+	}
+	if len(deadLettered) != 2 || deadLettered[0].DeadLetter.Reason != "expired" {
+		t.Fatalf("dead-lettered %+v, want IDs 1 and 3 as expired", deadLettered)
+	}
+	if stats := topic.Stats(); stats.Expired != 2 || stats.InFlight != 1 {
+		t.Fatalf("stats %+v, want 2 expired and 1 in flight", stats)
+	}
+
+	// The topic default applies when the producer sets no expiry
+	topic.Enqueue("payload")
+	for range restarts {
+		topic = restart(t, topic)
+	}
+
+	msg, ok := topic.Dequeue(DefaultGroup)
+	if !ok || msg.ID != 4 {
+		t.Fatalf("got ID %d (ok=%v), want 4: expired messages came back", msg.ID, ok)
+	}
+	if msg.ExpiresAt.IsZero() {
+		t.Fatal("topic MessageTTL not applied")
+	}
+}
+
+func TestDedupKeysSurviveRestart(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.DedupMaxKeys = 2
+	topic := openTopic(t, "orders", config)
+
+	publish := func(key string) int64 {
+		t.Helper()
+		ids, err := topic.EnqueueMessages([]Message{{Payload: []byte("payload"), DedupKey: key}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids[0]
+	}
+
+	if a, b := publish("a"), publish("a"); a != 1 || b != 1 {
+		t.Fatalf("duplicate publish got IDs %d and %d, want 1 twice", a, b)
+	}
+	publish("b")
+
+	// Acked messages are compacted away, their keys must not be
+	msg, _ := topic.Dequeue(DefaultGroup)
+	topic.Acknowledge(DefaultGroup, msg.Receipt)
+
+	topic = restartAlternating(t, topic, restarts)
+
+	if id := publish("a"); id != 1 {
+		t.Fatalf("duplicate after restart got ID %d, want 1", id)
+	}
+	expectIDs(t, topic, []int64{2})
+
+	// Only the newest DedupMaxKeys keys are remembered
+	publish("c")
+	if id := publish("a"); id == 1 {
+		t.Fatal("evicted key still deduplicated")
+	}
+}
+
+func TestPriorityLanesKeepFIFOWithinLevel(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.PriorityLevels = 2
+	config.PriorityWeights = []int{1, 3}
+	topic := openTopic(t, "orders", config)
+
+	// Bulk backfill first, then urgent alerts
+	var msgs []Message
+	for range 4 {
+		msgs = append(msgs, Message{Payload: []byte("bulk")})
+	}
+	for range 6 {
+		msgs = append(msgs, Message{Payload: []byte("urgent"), Priority: 1})
+	}
+	topic.EnqueueMessages(msgs)
+
+	for range restarts {
+		topic = restart(t, topic)
+	}
+
+	// Urgent gets 3 turns for each bulk one, each level stays in order
+	expectIDs(t, topic, []int64{5, 6, 1, 7, 8, 9, 2, 10, 3, 4})
+}
+
+func TestFullPriorityLaneStillDelivers(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// Two lanes of 2 slots, the urgent one filled exactly
+	config := orderConfig
+	config.PriorityLevels = 2
+	config.Capacity = 4
+	topic := openTopic(t, "orders", config)
+	topic.EnqueueMessages([]Message{
+		{Payload: []byte("urgent"), Priority: 1},
+		{Payload: []byte("urgent"), Priority: 1},
+		{Payload: []byte("bulk")},
+	})
+
+	if msgs, _ := topic.DequeueBatch(DefaultGroup, 3); len(msgs) != 3 {
+		t.Fatalf("got %d of 3 messages", len(msgs))
+	}
+}
+
+func TestMessageGroupsDeliverInOrder(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	topic := openTopic(t, "orders", orderConfig)
+	var msgs []Message
+	for _, key := range []string{"alice", "alice", "bob", "alice", "bob"} {
+		msgs = append(msgs, Message{Payload: []byte("payload"), MessageGroup: key})
+	}
+	topic.EnqueueMessages(msgs)
+
+	// One message per message group at a time: alice 1 and bob 3
+	first, _ := topic.DequeueBatch(DefaultGroup, 10)
+	if len(first) != 2 || first[0].ID != 1 || first[1].ID != 3 {
+		t.Fatalf("first batch %v, want IDs 1 and 3", first)
+	}
+
+	// alice 1 fails and must come back before alice 2 and 4
+	if err := topic.Nack(DefaultGroup, first[0].Receipt, 0); err != nil {
+		t.Fatal(err)
+	}
+	topic.Acknowledge(DefaultGroup, first[1].Receipt)
+
+	topic = restartAlternating(t, topic, restarts)
+
+	var order []int64
+	for {
+		batch, _ := topic.DequeueBatch(DefaultGroup, 10)
+		if len(batch) == 0 {
+			break
+		}
+		for _, msg := range batch {
+			order = append(order, msg.ID)
+			topic.Acknowledge(DefaultGroup, msg.Receipt)
+		}
+	}
+	if got := fmt.Sprint(order); got != "[1 5 2 4]" {
+		t.Fatalf("delivery order %s, want [1 5 2 4]", got)
+	}
+}
+
+func TestMaxRetriesRoutesToDeadLetterTopic(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.MaxRetries = 2
+	registry := NewTopicRegistry(config)
+	registry.CreateTopic("orders")
+	topic := registry.GetTopic("orders")
+	topic.Enqueue("payload")
+
+	// Delivered once, then retried twice, each time never acked
+	for attempt := range config.MaxRetries + 1 {
+		if _, ok := topic.Dequeue(DefaultGroup); !ok {
+			t.Fatalf("attempt %d: message not redelivered", attempt+1)
+		}
+		topic.retryExpired(afterAckTimeout())
+	}
+	if _, ok := topic.Dequeue(DefaultGroup); ok {
+		t.Fatal("message delivered past MaxRetries")
+	}
+
+	// Dead letters and the removal from the topic survive a restart
+	registry.Close()
+	registry = NewTopicRegistry(config)
+	registry.LoadTopicFromDisk(config)
+	defer registry.Close()
+
+	if stats := registry.GetTopic("orders").Stats(); stats.Pending != 0 || stats.InFlight != 0 {
+		t.Fatalf("origin still holds the message: %+v", stats)
+	}
+	dead := registry.GetDeadLetterTopic("orders").Pending(0)
+	want := &DeadLetter{Topic: "orders", Group: DefaultGroup, ID: 1, Retries: 2, Reason: "max retries exceeded"}
+	if len(dead) != 1 || !reflect.DeepEqual(dead[0].DeadLetter, want) || string(dead[0].Payload) != "payload" {
+		t.Fatalf("got dead letters %+v, want one with %+v", dead, want)
+	}
+}
+
+func TestRefusedDeadLettersAreKept(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.MaxRetries = 0
+	config.DeadLetterExpired = true
+	topic := openTopic(t, "orders", config)
+
+	refuse := true
+	var deadLettered []int64
+	sink := func(msg Message) error {
+		if refuse {
+			return ErrTopicClosed // eg: the dead-letter topic closed first on shutdown
+		}
+		deadLettered = append(deadLettered, msg.DeadLetter.ID)
+		return nil
+	}
+	topic.SetDeadLetterSink(sink)
+
+	// Out of retries once nacked, once timed out and once expired
+	topic.Enqueue("nacked")
+	topic.Enqueue("timed out")
+	topic.EnqueueMessages([]Message{{Payload: []byte("expired"), ExpiresAt: time.Now().Add(-time.Second)}})
+	msg, _ := topic.Dequeue(DefaultGroup)
+	if err := topic.Nack(DefaultGroup, msg.Receipt, 0); err != nil {
+		t.Fatal(err)
+	}
+	topic.Dequeue(DefaultGroup)
+	topic.retryExpired(afterAckTimeout())
+	if _, ok := topic.Dequeue(DefaultGroup); ok {
+		t.Fatal("expired message delivered")
+	}
+	if stats := topic.Stats(); len(deadLettered) != 0 || stats.InFlight != 2 || stats.Delayed != 1 || stats.Expired != 0 {
+		t.Fatalf("dead-lettered %v, stats %+v: refused messages not kept", deadLettered, stats)
+	}
+
+	topic = restart(t, topic)
+	defer func() { topic.Close() }()
+	topic.SetDeadLetterSink(sink)
+	if stats := topic.Stats(); stats.InFlight != 2 || stats.Pending != 1 {
+		t.Fatalf("stats %+v after a restart, want 2 in flight and 1 pending", stats)
+	}
+
+	refuse = false
+	topic.retryExpired(afterAckTimeout())
+	topic.Dequeue(DefaultGroup)
+	if !slices.Equal(deadLettered, []int64{1, 2, 3}) {
+		t.Fatalf("dead-lettered %v, want 1, 2 and 3", deadLettered)
+	}
+
+	topic = restart(t, topic)
+	if stats := topic.Stats(); stats.InFlight != 0 || stats.Pending != 0 {
+		t.Fatalf("stats %+v, dead letters came back", stats)
+	}
+}
+
+func TestDeadLetterRedriveKeepsMessageFields(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	registry := NewTopicRegistry(orderConfig)
+	defer registry.Close()
+	config := orderConfig
+	config.MaxRetries = 0
+	config.PriorityLevels = 2
+	if _, err := registry.PutTopic("orders", config); err != nil {
+		t.Fatal(err)
+	}
+	topic := registry.GetTopic("orders")
+
+	sent := []Message{
+		{Payload: []byte("a"), MessageGroup: "customer-1", Priority: 1, DedupKey: "order-1"},
+		{Payload: []byte("b"), MessageGroup: "customer-1", Priority: 1, DedupKey: "order-2"},
+	}
+	topic.EnqueueMessages(slices.Clone(sent))
+	for range sent {
+		msg, _ := topic.Dequeue(DefaultGroup)
+		if err := topic.Nack(DefaultGroup, msg.Receipt, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fields := func(msgs []Message) []Message {
+		var got []Message
+		for _, m := range msgs {
+			got = append(got, Message{Payload: m.Payload, MessageGroup: m.MessageGroup, Priority: m.Priority, DedupKey: m.DedupKey})
+		}
+		return got
+	}
+	if got := fields(registry.GetDeadLetterTopic("orders").Pending(0)); !reflect.DeepEqual(got, sent) {
+		t.Fatalf("dead letters are %+v, want %+v", got, sent)
+	}
+
+	// The dedup keys are still remembered by the topic, the redriven
+	// messages must not be taken for duplicates
+	if n, err := registry.Redrive("orders"); err != nil || n != 2 {
+		t.Fatalf("redrove %d messages (%v), want 2", n, err)
+	}
+	if got := fields(topic.Pending(0)); !reflect.DeepEqual(got, sent) {
+		t.Fatalf("redriven messages are %+v, want %+v", got, sent)
+	}
+
+	// The message group still delivers one at a time, in order
+	first, _ := topic.Dequeue(DefaultGroup)
+	if _, ok := topic.Dequeue(DefaultGroup); ok || string(first.Payload) != "a" {
+		t.Fatalf("got %q first and the second message alongside it", first.Payload)
+	}
+}
+
+func TestRetryBackoffSurvivesRestart(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	config := orderConfig
+	config.Retry = RetryPolicy{Kind: RetryExponential, Delay: time.Hour}
+	topic := openTopic(t, "orders", config)
+	topic.Enqueue("payload")
+
+	msg, _ := topic.Dequeue(DefaultGroup)
+	timedOut := afterAckTimeout()
+	topic.retryExpired(timedOut)
+	if _, ok := topic.Dequeue(DefaultGroup); ok {
+		t.Fatal("retried message visible before its backoff elapsed")
+	}
+
+	// The backoff is logged, so a restart doesn't make it visible early
+	topic = restart(t, topic)
+	if stats := topic.Stats(); stats.Delayed != 1 {
+		t.Fatalf("got %d delayed messages after restart, want 1", stats.Delayed)
+	}
+	if _, ok := topic.Dequeue(DefaultGroup); ok {
+		t.Fatal("retried message visible after restart before its backoff elapsed")
+	}
+
+	topic.releaseDue(timedOut.Add(time.Hour))
+	got, ok := topic.Dequeue(DefaultGroup)
+	if !ok || got.ID != msg.ID || got.Retries != 1 {
+		t.Fatalf("got %+v (%v), want msg %d on retry 1", got, ok, msg.ID)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	exponential := RetryPolicy{Kind: RetryExponential, Delay: time.Second, MaxDelay: 5 * time.Second}
+	schedule := RetryPolicy{Kind: RetrySchedule, Schedule: []time.Duration{time.Second, time.Minute}}
+
+	tests := []struct {
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{RetryPolicy{Delay: time.Second}, 3, time.Second},
+		{exponential, 1, time.Second},
+		{exponential, 3, 4 * time.Second},
+		{exponential, 10, 5 * time.Second},
+		{schedule, 1, time.Second},
+		{schedule, 2, time.Minute},
+		{schedule, 5, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.Backoff(tt.attempt); got != tt.want {
+			t.Errorf("%s attempt %d: got %v, want %v", tt.policy.Kind, tt.attempt, got, tt.want)
+		}
+	}
+
+	jittered := RetryPolicy{Kind: RetryExponential, Delay: time.Second, Jitter: 0.5}
+	for range 100 {
+		if got := jittered.Backoff(2); got < time.Second || got > 2*time.Second {
+			t.Fatalf("jittered backoff %v outside [1s, 2s]", got)
+		}
+	}
+}
+
+func TestCloseWithinReportsSlowTopics(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	registry := NewTopicRegistry(orderConfig)
+	registry.CreateTopic("orders")
+	registry.CreateTopic("payments")
+
+	// A topic busy under its lock can't start closing
+	stuck := registry.GetTopic("payments")
+	stuck.mu.Lock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if pending := registry.CloseWithin(ctx); !slices.Equal(pending, []string{"payments"}) {
+		t.Fatalf("got %v not closed, want [payments]", pending)
+	}
+	if _, err := registry.GetTopic("orders").Enqueue("a"); !errors.Is(err, ErrTopicClosed) {
+		t.Fatalf("got %v publishing to orders, want ErrTopicClosed", err)
+	}
+
+	// Closing again waits for the close in progress
+	stuck.mu.Unlock()
+	if pending := registry.CloseWithin(context.Background()); pending != nil {
+		t.Fatalf("got %v not closed, want none", pending)
+	}
+}
+
+func TestPurgeAndDeleteTopic(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	registry := NewTopicRegistry(orderConfig)
+	registry.CreateTopic("orders")
+	registry.CreateTopic("payments")
+	if got := registry.Topics(); !slices.Equal(got, []string{"orders", "payments"}) {
+		t.Fatalf("got topics %v", got)
+	}
+
+	topic := registry.GetTopic("orders")
+	for range 3 {
+		topic.Enqueue("payload")
+	}
+	topic.Dequeue(DefaultGroup)
+
+	info := topic.Describe()
+	if info.Pending != 2 || info.InFlight != 1 || info.NextID != 4 {
+		t.Fatalf("got %+v, want 2 pending, 1 in flight, next ID 4", info)
+	}
+
+	// Purged messages stay gone after a restart, in-flight ones are kept
+	if n := topic.Purge(); n != 2 {
+		t.Fatalf("purged %d messages, want 2", n)
+	}
+	topic = restart(t, topic)
+	if stats := topic.Stats(); stats.Pending != 0 || stats.InFlight != 1 {
+		t.Fatalf("got %+v after restart, want 0 pending, 1 in flight", stats)
+	}
+	topic.Close()
+
+	if err := registry.DeleteTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(topicDir("orders")); !os.IsNotExist(err) {
+		t.Fatalf("topic files left behind: %v", err)
+	}
+	if registry.GetTopic("orders") != nil || !slices.Equal(registry.Topics(), []string{"payments"}) {
+		t.Fatal("deleted topic still registered")
+	}
+	if err := registry.DeleteTopic("orders"); err != ErrTopicNotFound {
+		t.Fatalf("got %v, want ErrTopicNotFound", err)
+	}
+}
+
+func TestRecreateDuringDeleteKeepsFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	registry := NewTopicRegistry(orderConfig)
+	defer registry.Close()
+	for range 100 {
+		registry.CreateTopic("orders")
+
+		// A producer auto-creates the topic as soon as it's unregistered,
+		// while its files are still being deleted
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			registry.DeleteTopic("orders")
+		}()
+		go func() {
+			defer wg.Done()
+			for registry.GetTopic("orders") != nil {
+				runtime.Gosched()
+			}
+			registry.CreateTopic("orders")
+		}()
+		wg.Wait()
+
+		if ids, err := listSegments(topicDir("orders")); err != nil || len(ids) == 0 {
+			t.Fatalf("recreated topic lost its segments: %v", err)
+		}
+		if err := registry.DeleteTopic("orders"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeadLetterLookupWhileUpdatingConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	registry := NewTopicRegistry(orderConfig)
+	defer registry.Close()
+	registry.CreateTopic("orders")
+	registry.CreateTopic("orders.dlq")
+
+	// Run with -race: the lookup reads the config UpdateConfig writes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		config := orderConfig
+		for i := range 50 {
+			config.MaxRetries = i
+			registry.PutTopic("orders", config)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if registry.GetDeadLetterTopic("orders") == nil {
+			t.Fatal("dead-letter topic not found")
+		}
+	}
+}
+
+func TestCreateTopicRejectsInvalidNames(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	registry := NewTopicRegistry(orderConfig)
+	defer registry.Close()
+	long := strings.Repeat("a", maxTopicNameLen+1)
+	for _, name := range []string{"", ".", "..", "a/b", `a\b`, long, long + deadLetterSuffix} {
+		if err := registry.CreateTopic(name); !errors.Is(err, ErrInvalidTopicName) {
+			t.Errorf("topic %q: got %v, want ErrInvalidTopicName", name, err)
+		}
+	}
+	if topics := registry.Topics(); len(topics) != 0 {
+		t.Fatalf("got topics %v", topics)
+	}
+
+	config := orderConfig
+	config.DeadLetterTopic = "dlq/orders"
+	if _, err := registry.PutTopic("orders", config); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("got %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestOversizedConfigsAreRejected(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	registry := NewTopicRegistry(orderConfig)
+	defer registry.Close()
+
+	for _, oversize := range []func(*TopicConfig){
+		func(c *TopicConfig) { c.Capacity = 1 << 40 },
+		func(c *TopicConfig) { c.PriorityLevels = MaxPriorityLevels + 1 },
+		func(c *TopicConfig) { c.WALChannelDepth = 1 << 40 },
+	} {
+		config := orderConfig
+		oversize(&config)
+		if _, err := registry.PutTopic("orders", config); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("config %+v: got %v, want ErrInvalidConfig", config, err)
+		}
+	}
+	if topics := registry.Topics(); len(topics) != 0 {
+		t.Fatalf("got topics %v", topics)
+	}
+}
+
+func TestLongestTopicNamesHaveDeadLetterTopics(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	registry := NewTopicRegistry(orderConfig)
+	defer registry.Close()
+
+	name := strings.Repeat("a", maxTopicNameLen)
+	if err := registry.CreateTopic(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.CreateTopic(name + deadLetterSuffix); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoveryRejectsTopicsWithoutValidDeadLetterNames(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// Written by a version without a name limit, its dead-letter topic
+	// would be too long for a directory name
+	for _, name := range []string{strings.Repeat("a", 254), "orders"} {
+		topic := openTopic(t, name, orderConfig)
+		topic.Enqueue("payload")
+		topic.Close()
+	}
+
+	registry := NewTopicRegistry(orderConfig)
+	defer registry.Close()
+	if err := registry.LoadTopicFromDisk(orderConfig); !errors.Is(err, ErrInvalidTopicName) {
+		t.Fatalf("got %v, want ErrInvalidTopicName", err)
+	}
+	if topics := registry.Topics(); !slices.Equal(topics, []string{"orders"}) {
+		t.Fatalf("got topics %v, want [orders]", topics)
+	}
+}
+
+func TestFailedTopicOpenReleasesRegistry(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// A file where the topic's directory should be
+	if err := os.MkdirAll(DataDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(topicDir("orders"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewTopicRegistry(orderConfig)
+	defer registry.Close()
+	if err := registry.CreateTopic("orders"); err == nil {
+		t.Fatal("created a topic without a directory")
+	}
+	if _, err := registry.PutTopic("orders", orderConfig); err == nil {
+		t.Fatal("put a topic without a directory")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		registry.CreateTopic("payments")
+		registry.Topics()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("registry still locked")
+	}
+	if topics := registry.Topics(); !slices.Equal(topics, []string{"payments"}) {
+		t.Fatalf("got topics %v, want [payments]", topics)
+	}
+}
+
+func TestSmallCapacitiesDeliver(t *testing.T) {
+	for _, levels := range []int{0, 2} {
+		for _, capacity := range []int64{1, 2, 3} {
+			t.Run(fmt.Sprintf("levels=%d,capacity=%d", levels, capacity), func(t *testing.T) {
+				t.Chdir(t.TempDir())
+
+				registry := NewTopicRegistry(orderConfig)
+				defer registry.Close()
+				config := orderConfig
+				config.Capacity = capacity
+				config.PriorityLevels = levels
+				if _, err := registry.PutTopic("orders", config); err != nil {
+					t.Fatal(err)
+				}
+
+				// Each buffer fills exactly, then has to grow
+				topic := registry.GetTopic("orders")
+				for i := range 5 {
+					topic.EnqueueMessages([]Message{{Payload: []byte("payload"), Priority: i % 2}})
+				}
+				if msgs, _ := topic.DequeueBatch(DefaultGroup, 5); len(msgs) != 5 {
+					t.Fatalf("got %d of 5 messages", len(msgs))
+				}
+			})
+		}
+	}
+}
+
+func TestTopicConfigSurvivesRestart(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	registry := NewTopicRegistry(orderConfig)
+	config := orderConfig
+	config.MaxRetries = 7
+	config.PriorityLevels = 3
+	config.Retry = RetryPolicy{Kind: RetrySchedule, Schedule: []time.Duration{time.Second, time.Minute}}
+	if created, err := registry.PutTopic("orders", config); err != nil || !created {
+		t.Fatalf("got created=%v, %v", created, err)
+	}
+
+	// Live settings change in place, the others are fixed
+	config.AckTimeout = 2 * time.Minute
+	config.MessageTTL = time.Hour
+	if created, err := registry.PutTopic("orders", config); err != nil || created {
+		t.Fatalf("got created=%v, %v", created, err)
+	}
+	fixed := config
+	fixed.PriorityLevels = 2
+	if _, err := registry.PutTopic("orders", fixed); !errors.Is(err, ErrConfigFixed) {
+		t.Fatalf("got %v, want ErrConfigFixed", err)
+	}
+	invalid := config
+	invalid.AckTimeout = 0
+	if _, err := registry.PutTopic("orders", invalid); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("got %v, want ErrInvalidConfig", err)
+	}
+
+	registry.GetTopic("orders").Close()
+	registry = NewTopicRegistry(orderConfig)
+	registry.LoadTopicFromDisk(orderConfig)
+	topic := registry.GetTopic("orders")
+	if topic == nil {
+		t.Fatal("topic not recovered")
+	}
+	defer topic.Close()
+	if got := topic.Config(); !reflect.DeepEqual(got, config) {
+		t.Fatalf("got config %+v after restart, want %+v", got, config)
+	}
+
+	// JSON updates only touch the settings they name
+	update := topic.Config()
+	if err := json.Unmarshal([]byte(`{"max_retries": 2, "retry": {"schedule": ["5s"]}}`), &update); err != nil {
+		t.Fatal(err)
+	}
+	want := config
+	want.MaxRetries = 2
+	want.Retry.Schedule = []time.Duration{5 * time.Second}
+	if !reflect.DeepEqual(update, want) {
+		t.Fatalf("got %+v, want %+v", update, want)
+	}
+}
+
+func TestRegistryCloseFlushesEveryTopic(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// Nothing reaches the disk before Close
+	config := orderConfig
+	config.WALFlushInterval = time.Hour
+	registry := NewTopicRegistry(config)
+	for _, name := range []string{"orders", "payments"} {
+		registry.CreateTopic(name)
+		registry.GetTopic(name).EnqueueBatch([]string{"a", "b"})
+	}
+	registry.Close()
+
+	if _, err := registry.GetTopic("orders").Enqueue("late"); err != ErrTopicClosed {
+		t.Fatalf("got %v, want ErrTopicClosed", err)
+	}
+
+	registry = NewTopicRegistry(config)
+	registry.LoadTopicFromDisk(config)
+	defer registry.Close()
+	for _, name := range []string{"orders", "payments"} {
+		expectIDs(t, registry.GetTopic(name), []int64{1, 2})
+	}
+}
+
+func TestRegistryHealthTracksRecoveryAndWAL(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	registry := NewTopicRegistry(orderConfig)
+	registry.CreateTopic("orders")
+	registry.Close()
+
+	registry = NewTopicRegistry(orderConfig)
+	if registry.Recovered() {
+		t.Fatal("recovered before loading topics")
+	}
+	registry.LoadTopicFromDisk(orderConfig)
+	if !registry.Recovered() {
+		t.Fatal("not recovered after loading topics")
+	}
+	if problems := registry.Health(); len(problems) != 0 {
+		t.Fatalf("got problems %v", problems)
+	}
+
+	topic := registry.GetTopic("orders")
+	for range walFailureThreshold {
+		topic.wal.recordFlush(errors.New("disk full"))
+	}
+	if err := registry.Health()["orders"]; err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("got %v, want the flush error", err)
+	}
+	topic.wal.recordFlush(nil)
+	if err := topic.Health(); err != nil {
+		t.Fatalf("got %v after a good flush", err)
+	}
+
+	registry.Close()
+	if err := topic.Health(); err == nil {
+		t.Fatal("closed topic reported healthy")
+	}
+}
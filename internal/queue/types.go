@@ -7,23 +7,130 @@ type Queue[T any] interface {
 	Dequeue() (T, bool)
 	Size() int64
 	Cap() int64
+	Items() []T
 }
 
+// TopicConfig holds the settings of a topic. In JSON keys are snake_case
+// and durations are strings, see config.go
 type TopicConfig struct {
 	AckTimeout time.Duration
 	MaxRetries int
+
+	// Initial size of each consumer group's queue, it grows as needed
+	// (0 = DefaultCapacity, at most MaxCapacity)
+	Capacity int64
+
+	// Backoff before a timed-out message is delivered again
+	// (default: right away)
+	Retry RetryPolicy
+
+	// Longest a single delivery can be held by extending its lease,
+	// counted from delivery (0 = DefaultMaxLease)
+	MaxLease time.Duration
+
+	// WAL segments roll once they reach SegmentMaxBytes
+	// (default DefaultSegmentMaxBytes) or get older than
+	// SegmentMaxAge (0 = never roll on age)
+	SegmentMaxBytes int64
+	SegmentMaxAge   time.Duration
+
+	// How often live state is snapshotted so covered segments
+	// can be deleted (0 = DefaultCheckpointInterval, <0 = never)
+	CheckpointInterval time.Duration
+
+	// Topic receiving messages that exceeded MaxRetries.
+	// Defaults to "<topic>.dlq"
+	DeadLetterTopic string
+
+	// How long a message may wait for delivery unless the producer sets
+	// its own expiry (0 = forever). Expired messages are dropped, or
+	// dead-lettered if DeadLetterExpired is set
+	MessageTTL        time.Duration
+	DeadLetterExpired bool
+
+	// How long, and for how many keys, producer deduplication keys are
+	// remembered (0 = DefaultDedupWindow / DefaultDedupMaxKeys)
+	DedupWindow  time.Duration
+	DedupMaxKeys int
+
+	// Number of priority levels (0 or 1 = plain FIFO, at most
+	// MaxPriorityLevels). Message.Priority
+	// picks the level, higher is served more often; PriorityWeights sets
+	// the turns each level gets (default doubles per level)
+	PriorityLevels  int
+	PriorityWeights []int
+
+	// When WAL writes are fsynced, see Durability.
+	// SyncInterval applies to DurabilityInterval (0 = DefaultSyncInterval)
+	Durability   Durability
+	SyncInterval time.Duration
+
+	// WAL writer tuning: appends queued before producers block
+	// (0 = DefaultWALChannelDepth, at most MaxWALChannelDepth), entries
+	// written per batch (0 = DefaultWALBatchSize) and how often a
+	// partial batch is flushed (0 = DefaultWALFlushInterval)
+	WALChannelDepth  int
+	WALBatchSize     int
+	WALFlushInterval time.Duration
 }
 
+// Durability controls when WAL writes are fsynced to disk
+type Durability string
+
+const (
+	// Writes are flushed to the OS but never fsynced (default)
+	DurabilityNone Durability = ""
+	// The active segment is fsynced every SyncInterval
+	DurabilityInterval Durability = "interval"
+	// Each batch is fsynced before its producers are acknowledged
+	DurabilityGroupCommit Durability = "group-commit"
+)
+
 // Message is a simple struct holding the message and data
 type Message struct {
 	ID        int64     `json:"id,omitempty"`
-	Payload   string    `json:"payload,omitempty"`
+	Payload   []byte    `json:"payload,omitempty"`   // base64 in JSON
 	Timestamp time.Time `json:"timestamp,omitempty"` // When it was delivered
 	Acked     bool      `json:"acked,omitempty"`     // Whether it's been acknowledged
 	Retries   int       `json:"retries,omitempty"`
+	Receipt   string    `json:"receipt,omitempty"` // Settles this delivery, see Topic.Acknowledge
+
+	// Not delivered before then, zero = right away
+	DeliverAt time.Time `json:"deliver_at,omitzero"`
+	// Not delivered from then on, zero = never expires
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+
+	// 0 is the lowest, see TopicConfig.PriorityLevels
+	Priority int `json:"priority,omitempty"`
+	// Messages of the same group are delivered one at a time, in order
+	MessageGroup string `json:"message_group,omitempty"`
+
+	// Producer metadata, eg: content type or trace ID
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Set by the producer so a retried publish isn't enqueued twice
+	DedupKey   string    `json:"dedup_key,omitempty"`
+	EnqueuedAt time.Time `json:"enqueued_at,omitzero"`
+
+	// Set only on messages sitting in a dead-letter topic
+	DeadLetter *DeadLetter `json:"dead_letter,omitempty"`
+}
+
+// DeadLetter records where a dead-lettered message came from and why
+type DeadLetter struct {
+	Topic   string `json:"topic"`           // original topic
+	Group   string `json:"group,omitempty"` // consumer group that gave up on it
+	ID      int64  `json:"id"`              // ID in the original topic
+	Retries int    `json:"retries"`
+	Reason  string `json:"reason"`
 }
 
 type LogEntry struct {
-	Type    string // "enqueue" | "deliver" | "ack"
+	Type    string // "enqueue" | "deliver" | "extend" | "ack" | "nack" | "retry" | "dead" | "expire" | "purge" | "subscribe" | "unsubscribe" | "dedup"
+	Group   string // consumer group, "" on "enqueue" means every group
 	Message Message
+
+	// "enqueue", "nack" and "retry": when the message is deliverable, zero if right away.
+	// "deliver" and "extend": the lease deadline, zero if AckTimeout applies
+	DueAt time.Time
 }
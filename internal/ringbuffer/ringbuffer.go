@@ -48,7 +48,8 @@ func (r *RingBuffer[T]) Dequeue() (T, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if r.size == 0 || r.head == r.tail {
+	// head == tail both when empty and when full, only size tells them apart
+	if r.size == 0 {
 		var t T
 		return t, false
 	}
@@ -64,6 +65,19 @@ func (r *RingBuffer[T]) Dequeue() (T, bool) {
 	return item, true
 }
 
+// Returns a copy of the queued elements from head to tail
+func (r *RingBuffer[T]) Items() []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items := make([]T, r.size)
+	for i := range r.size {
+		items[i] = r.queue[(r.head+i)%r.cap]
+	}
+
+	return items
+}
+
 func (r *RingBuffer[T]) Size() int64 {
 	return r.size
 }
@@ -8,10 +8,22 @@ import (
 
 func FromMessage(msg queue.Message) *Consume {
 	return &Consume{
-		Id:        msg.ID,
-		Payload:   msg.Payload,
-		Acked:     msg.Acked,
-		Timestamp: msg.Timestamp.Format(time.RFC3339Nano),
-		Retries:   int32(msg.Retries),
+		Id:           msg.ID,
+		Payload:      msg.Payload,
+		Acked:        msg.Acked,
+		Timestamp:    msg.Timestamp.Format(time.RFC3339Nano),
+		Retries:      int32(msg.Retries),
+		Receipt:      msg.Receipt,
+		Headers:      msg.Headers,
+		Priority:     int32(msg.Priority),
+		MessageGroup: msg.MessageGroup,
 	}
 }
+
+func FromMessages(msgs []queue.Message) *ConsumeBatch {
+	batch := &ConsumeBatch{Messages: make([]*Consume, len(msgs))}
+	for i, msg := range msgs {
+		batch.Messages[i] = FromMessage(msg)
+	}
+	return batch
+}
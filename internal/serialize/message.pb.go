@@ -24,6 +24,14 @@ const (
 type Produce struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Delay         string                 `protobuf:"bytes,2,opt,name=delay,proto3" json:"delay,omitempty"`
+	DeliverAt     string                 `protobuf:"bytes,3,opt,name=deliver_at,json=deliverAt,proto3" json:"deliver_at,omitempty"`
+	Ttl           string                 `protobuf:"bytes,4,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	Payload       []byte                 `protobuf:"bytes,5,opt,name=payload,proto3" json:"payload,omitempty"`
+	Headers       map[string]string      `protobuf:"bytes,6,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	DedupKey      string                 `protobuf:"bytes,7,opt,name=dedup_key,json=dedupKey,proto3" json:"dedup_key,omitempty"`
+	Priority      int32                  `protobuf:"varint,8,opt,name=priority,proto3" json:"priority,omitempty"`
+	MessageGroup  string                 `protobuf:"bytes,9,opt,name=message_group,json=messageGroup,proto3" json:"message_group,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -65,13 +73,73 @@ func (x *Produce) GetMessage() string {
 	return ""
 }
 
+func (x *Produce) GetDelay() string {
+	if x != nil {
+		return x.Delay
+	}
+	return ""
+}
+
+func (x *Produce) GetDeliverAt() string {
+	if x != nil {
+		return x.DeliverAt
+	}
+	return ""
+}
+
+func (x *Produce) GetTtl() string {
+	if x != nil {
+		return x.Ttl
+	}
+	return ""
+}
+
+func (x *Produce) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *Produce) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *Produce) GetDedupKey() string {
+	if x != nil {
+		return x.DedupKey
+	}
+	return ""
+}
+
+func (x *Produce) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *Produce) GetMessageGroup() string {
+	if x != nil {
+		return x.MessageGroup
+	}
+	return ""
+}
+
 type Consume struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
-	Payload       string                 `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	Payload       []byte                 `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
 	Acked         bool                   `protobuf:"varint,3,opt,name=acked,proto3" json:"acked,omitempty"`
 	Timestamp     string                 `protobuf:"bytes,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
 	Retries       int32                  `protobuf:"varint,5,opt,name=retries,proto3" json:"retries,omitempty"`
+	Receipt       string                 `protobuf:"bytes,6,opt,name=receipt,proto3" json:"receipt,omitempty"`
+	Headers       map[string]string      `protobuf:"bytes,7,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Priority      int32                  `protobuf:"varint,8,opt,name=priority,proto3" json:"priority,omitempty"`
+	MessageGroup  string                 `protobuf:"bytes,9,opt,name=message_group,json=messageGroup,proto3" json:"message_group,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -113,11 +181,11 @@ func (x *Consume) GetId() int64 {
 	return 0
 }
 
-func (x *Consume) GetPayload() string {
+func (x *Consume) GetPayload() []byte {
 	if x != nil {
 		return x.Payload
 	}
-	return ""
+	return nil
 }
 
 func (x *Consume) GetAcked() bool {
@@ -141,19 +209,158 @@ func (x *Consume) GetRetries() int32 {
 	return 0
 }
 
+func (x *Consume) GetReceipt() string {
+	if x != nil {
+		return x.Receipt
+	}
+	return ""
+}
+
+func (x *Consume) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *Consume) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *Consume) GetMessageGroup() string {
+	if x != nil {
+		return x.MessageGroup
+	}
+	return ""
+}
+
+type ProduceBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Messages      []*Produce             `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProduceBatch) Reset() {
+	*x = ProduceBatch{}
+	mi := &file_internal_serialize_message_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProduceBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProduceBatch) ProtoMessage() {}
+
+func (x *ProduceBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_serialize_message_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProduceBatch.ProtoReflect.Descriptor instead.
+func (*ProduceBatch) Descriptor() ([]byte, []int) {
+	return file_internal_serialize_message_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ProduceBatch) GetMessages() []*Produce {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+type ConsumeBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Messages      []*Consume             `protobuf:"bytes,1,rep,name=messages,proto3" json:"messages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsumeBatch) Reset() {
+	*x = ConsumeBatch{}
+	mi := &file_internal_serialize_message_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumeBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumeBatch) ProtoMessage() {}
+
+func (x *ConsumeBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_serialize_message_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumeBatch.ProtoReflect.Descriptor instead.
+func (*ConsumeBatch) Descriptor() ([]byte, []int) {
+	return file_internal_serialize_message_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ConsumeBatch) GetMessages() []*Consume {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
 var File_internal_serialize_message_proto protoreflect.FileDescriptor
 
 const file_internal_serialize_message_proto_rawDesc = "" +
 	"\n" +
-	" internal/serialize/message.proto\"#\n" +
+	" internal/serialize/message.proto\"\xcf\x02\n" +
 	"\aProduce\x12\x18\n" +
-	"\amessage\x18\x01 \x01(\tR\amessage\"\x81\x01\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage\x12\x14\n" +
+	"\x05delay\x18\x02 \x01(\tR\x05delay\x12\x1d\n" +
+	"\n" +
+	"deliver_at\x18\x03 \x01(\tR\tdeliverAt\x12\x10\n" +
+	"\x03ttl\x18\x04 \x01(\tR\x03ttl\x12\x18\n" +
+	"\apayload\x18\x05 \x01(\fR\apayload\x12/\n" +
+	"\aheaders\x18\x06 \x03(\v2\x15.Produce.HeadersEntryR\aheaders\x12\x1b\n" +
+	"\tdedup_key\x18\a \x01(\tR\bdedupKey\x12\x1a\n" +
+	"\bpriority\x18\b \x01(\x05R\bpriority\x12#\n" +
+	"\rmessage_group\x18\t \x01(\tR\fmessageGroup\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xc9\x02\n" +
 	"\aConsume\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x18\n" +
-	"\apayload\x18\x02 \x01(\tR\apayload\x12\x14\n" +
+	"\apayload\x18\x02 \x01(\fR\apayload\x12\x14\n" +
 	"\x05acked\x18\x03 \x01(\bR\x05acked\x12\x1c\n" +
 	"\ttimestamp\x18\x04 \x01(\tR\ttimestamp\x12\x18\n" +
-	"\aretries\x18\x05 \x01(\x05R\aretriesB>Z<github.com/suman7383/go-queue/internal/serialize;serializepbb\x06proto3"
+	"\aretries\x18\x05 \x01(\x05R\aretries\x12\x18\n" +
+	"\areceipt\x18\x06 \x01(\tR\areceipt\x12/\n" +
+	"\aheaders\x18\a \x03(\v2\x15.Consume.HeadersEntryR\aheaders\x12\x1a\n" +
+	"\bpriority\x18\b \x01(\x05R\bpriority\x12#\n" +
+	"\rmessage_group\x18\t \x01(\tR\fmessageGroup\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"4\n" +
+	"\fProduceBatch\x12$\n" +
+	"\bmessages\x18\x01 \x03(\v2\b.ProduceR\bmessages\"4\n" +
+	"\fConsumeBatch\x12$\n" +
+	"\bmessages\x18\x01 \x03(\v2\b.ConsumeR\bmessagesB>Z<github.com/suman7383/go-queue/internal/serialize;serializepbb\x06proto3"
 
 var (
 	file_internal_serialize_message_proto_rawDescOnce sync.Once
@@ -167,17 +374,25 @@ func file_internal_serialize_message_proto_rawDescGZIP() []byte {
 	return file_internal_serialize_message_proto_rawDescData
 }
 
-var file_internal_serialize_message_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_internal_serialize_message_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_internal_serialize_message_proto_goTypes = []any{
-	(*Produce)(nil), // 0: Produce
-	(*Consume)(nil), // 1: Consume
+	(*Produce)(nil),      // 0: Produce
+	(*Consume)(nil),      // 1: Consume
+	(*ProduceBatch)(nil), // 2: ProduceBatch
+	(*ConsumeBatch)(nil), // 3: ConsumeBatch
+	nil,                  // 4: Produce.HeadersEntry
+	nil,                  // 5: Consume.HeadersEntry
 }
 var file_internal_serialize_message_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	4, // 0: Produce.headers:type_name -> Produce.HeadersEntry
+	5, // 1: Consume.headers:type_name -> Consume.HeadersEntry
+	0, // 2: ProduceBatch.messages:type_name -> Produce
+	1, // 3: ConsumeBatch.messages:type_name -> Consume
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_internal_serialize_message_proto_init() }
@@ -191,7 +406,7 @@ func file_internal_serialize_message_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_serialize_message_proto_rawDesc), len(file_internal_serialize_message_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   0,
 		},